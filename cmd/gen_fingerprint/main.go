@@ -1,10 +1,115 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"license/internal/hwid"
+	"license/hwid"
+	"license/verify"
+	"os"
+	"strings"
 )
 
 func main() {
-	fmt.Println(hwid.GetFingerprint())
+	components := flag.Bool("components", false, "print per-component hashes as JSON")
+	collectors := flag.String("collectors", strings.Join(hwid.DefaultCollectors, ","), "comma separated hwid collectors composing the fingerprint")
+	file := flag.String("file", "", "path read by the file collector")
+	binding := flag.String("binding", hwid.BindingMachine, "fingerprint binding: machine, host-machine-id, k8s-cluster or volume")
+	hostMachineID := flag.String("host-machine-id", "", "host machine-id path mounted into the container")
+	volumeID := flag.String("volume-id", "", "instance id file on a persistent volume")
+	scheme := flag.Int("scheme", hwid.SchemeLegacy, "fingerprint scheme version")
+	salt := flag.String("salt", "", "base64 product fingerprint salt; components and fingerprint become HMAC-SHA256")
+	customer := flag.String("customer", "", "customer name for per-customer salting (requires -salt)")
+	explain := flag.Bool("explain", false, "print each component's source and hash and the final code as JSON")
+	licensePath := flag.String("license", "", "with -explain, license file to compare with this machine")
+	pubPath := flag.String("pub", "public.pem", "with -license, public key path")
+	key := flag.String("key", "", "with -license, base64 product encryption key for encrypted licenses")
+	full := flag.Bool("full", false, "print the full 256-bit fingerprint as hex instead of the activation code")
+	flag.Parse()
+
+	var saltKey []byte
+	if *salt != "" {
+		var err error
+		if saltKey, err = hwid.DecodeSalt(*salt); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if err := hwid.Configure(hwid.Config{
+		Collectors:    strings.Split(*collectors, ","),
+		File:          *file,
+		Binding:       *binding,
+		HostMachineID: *hostMachineID,
+		VolumeID:      *volumeID,
+		Scheme:        *scheme,
+		Salt:          saltKey,
+		Customer:      *customer,
+	}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *explain {
+		var out interface{} = hwid.Explain(*binding, *scheme)
+		if *licensePath != "" {
+			cmp, err := compareLicense(*licensePath, *pubPath, *key)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(4)
+			}
+			out = cmp
+		}
+		b, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	if *components {
+		b, _ := json.MarshalIndent(hwid.GetComponents(), "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	if *full {
+		digest, err := hwid.FingerprintDigestFor(*binding, *scheme)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(3)
+		}
+		fmt.Println(hex.EncodeToString(digest))
+		return
+	}
+	fp, err := hwid.FingerprintFor(*binding)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(3)
+	}
+	fmt.Println(fp)
+}
+
+// compareLicense 说明许可证与本机指纹哪些组件不一致，只验证签名，已过期的许可证也可以比较
+func compareLicense(licensePath, pubPath, key string) (*verify.Comparison, error) {
+	pub, err := verify.LoadPublicKey(pubPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load public key: %v", err)
+	}
+	b, err := os.ReadFile(licensePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read license: %v", err)
+	}
+	lf, err := verify.DecodeLicenseFile(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid license: %v", err)
+	}
+	var encryptionKey []byte
+	if key != "" {
+		if encryptionKey, err = base64.StdEncoding.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %v", err)
+		}
+	}
+	cl, err := verify.Decode(pub, lf.Token, encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid license: %v", err)
+	}
+	return verify.Compare(cl), nil
 }
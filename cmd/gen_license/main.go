@@ -1,9 +1,9 @@
 package main
 
 import (
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
-	"encoding/base32"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
@@ -13,6 +13,10 @@ import (
 	"strings"
 	"time"
 
+	"license/hwid"
+	"license/internal/license"
+	"license/verify"
+
 	"github.com/square/go-jose/v3"
 )
 
@@ -29,6 +33,17 @@ type licenseParam struct {
 	out         string
 	metaStr     string
 	issuer      string
+	product     string
+	request     string
+	binding     string
+	vmPolicy    string
+	instance    string
+	scheme      int
+	full        string
+	salt        string
+	features    string
+	encrypt     bool
+	encKey      string
 }
 
 // loadPrivateKey 支持 PKCS1 和 PKCS8 格式
@@ -55,22 +70,14 @@ func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
 	return nil, fmt.Errorf("unsupported private key format")
 }
 
-// decodeFingerprintToHex 支持把 "XXXX-XXXX-XXXX-XXXX" (base32) -> hex string
-// 当输入看起来像带 '-' 的机器码时调用
-func decodeFingerprintToHex(code string) (string, error) {
-	// remove dashes and spaces, uppercase
-	s := strings.ToUpper(strings.ReplaceAll(strings.ReplaceAll(code, "-", ""), " ", ""))
-	if len(s) != 16 {
-		return "", fmt.Errorf("activation code must be 16 base32 chars (4-4-4-4)")
-	}
-	// base32 decode, RFC4648, no padding
-	dec := base32.StdEncoding.WithPadding(base32.NoPadding)
-	// we expect 10 bytes
-	b, err := dec.DecodeString(s)
-	if err != nil {
-		return "", err
+// licenseID 随机的许可证编号，吊销列表按编号吊销
+func licenseID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate license id: %v\n", err)
+		os.Exit(8)
 	}
-	return hex.EncodeToString(b), nil
+	return hex.EncodeToString(b)
 }
 
 func main() {
@@ -80,18 +87,62 @@ func main() {
 	}
 	metaStr, err := json.Marshal(meta)
 	if err != nil {
-		fmt.Println(os.Stderr, "json marshal meta error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "json marshal meta error: %v\n", err)
 		os.Exit(8)
 	}
-	param := &licenseParam{
-		privPath:    "./private.pem",
-		customer:    "测试用户",
-		fingerprint: "DP3E-QBC7-POKI-APX6",
-		days:        10,
-		out:         "./license.lic",
-		metaStr:     string(metaStr),
-		issuer:      "lz",
+	param := &licenseParam{}
+	flag.StringVar(&param.privPath, "priv", "./private.pem", "private key path")
+	flag.StringVar(&param.customer, "customer", "", "customer name")
+	flag.StringVar(&param.fingerprint, "fingerprint", "", "machine fingerprint (XXXX-XXXX-XXXX-XXXX or hex)")
+	flag.IntVar(&param.days, "days", 10, "validity in days")
+	flag.StringVar(&param.out, "out", "./license.lic", "output license file")
+	flag.StringVar(&param.metaStr, "meta", string(metaStr), "meta json")
+	flag.StringVar(&param.issuer, "issuer", "lz", "issuer")
+	flag.StringVar(&param.product, "product", "", "product name written to the license file")
+	flag.StringVar(&param.request, "request", "", "offline activation request file; fingerprint and product are taken from it")
+	flag.StringVar(&param.binding, "binding", "", "fingerprint binding: machine, host-machine-id, k8s-cluster or volume")
+	flag.StringVar(&param.vmPolicy, "vm-policy", "", "virtual machine policy: physical-only, vm-allowed or vm-instance-uuid")
+	flag.StringVar(&param.instance, "instance-uuid", "", "instance uuid hash for the vm-instance-uuid policy")
+	flag.IntVar(&param.scheme, "scheme", 0, "fingerprint scheme version, taken from the activation code prefix when present")
+	flag.StringVar(&param.full, "full-fingerprint", "", "full 256-bit fingerprint (64 hex chars); the license then binds to the full digest")
+	flag.StringVar(&param.salt, "salt", "", "fingerprint salt mode of the product client: product or customer")
+	flag.StringVar(&param.features, "features", "", "comma-separated features granted by the license")
+	flag.BoolVar(&param.encrypt, "encrypt", false, "encrypt the license (JWE) with the product encryption key")
+	flag.StringVar(&param.encKey, "enc-key", "", "base64 product encryption key (32 bytes) used with -encrypt")
+	flag.Parse()
+
+	// offline activation: fingerprint, product and nonce come from the request file
+	nonce := ""
+	var components map[string]string
+	if param.request != "" {
+		b, err := os.ReadFile(param.request)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read activation request: %v\n", err)
+			os.Exit(11)
+		}
+		req, err := license.DecodeActivationRequest(string(b))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid activation request: %v\n", err)
+			os.Exit(11)
+		}
+		param.fingerprint = req.Fingerprint
+		param.product = req.Product
+		nonce = req.Nonce
+		components = req.Components
+		param.binding = req.Binding
+		param.scheme = req.Scheme
+		param.full = req.FullFingerprint
+		param.salt = req.Salt
+		// per-customer salted fingerprints only match licenses issued to that customer
+		if req.Salt == "customer" && req.SaltCustomer != param.customer {
+			fmt.Fprintf(os.Stderr, "fingerprint is salted for customer %q\n", req.SaltCustomer)
+			os.Exit(11)
+		}
+		if param.instance == "" {
+			param.instance = req.InstanceUUID
+		}
 	}
+
 	if param.customer == "" || param.fingerprint == "" {
 		fmt.Println("customer and fingerprint are required")
 		flag.Usage()
@@ -101,22 +152,40 @@ func main() {
 	// if fingerprint looks like activation code (contains '-'), decode it to hex
 	fp := param.fingerprint
 	if strings.Contains(param.fingerprint, "-") {
-		h, err := decodeActivationCodeToHex(param.fingerprint)
+		scheme, h, err := hwid.DecodeActivationCodeStrict(param.fingerprint)
 		if err != nil {
-			fmt.Println(os.Stderr, "failed to decode activation code: %v\n", err)
+			fmt.Fprintf(os.Stderr, "failed to decode activation code: %v\n", err)
 			os.Exit(3)
 		}
+		if param.scheme != 0 && param.scheme != scheme {
+			fmt.Fprintln(os.Stderr, "scheme does not match the activation code")
+			os.Exit(3)
+		}
+		param.scheme = scheme
 		fp = h
 	}
 
+	// encrypted licenses use the same JWE as licenses issued by the server for the product
+	var encKey []byte
+	if param.encrypt || param.encKey != "" {
+		if !param.encrypt || param.encKey == "" {
+			fmt.Fprintln(os.Stderr, "-encrypt and -enc-key must be used together")
+			os.Exit(2)
+		}
+		encKey, err = license.DecodeEncryptionKey(param.encKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+	}
+
 	priv, err := loadPrivateKey(param.privPath)
 	if err != nil {
-		fmt.Println(os.Stderr, "failed to load private key: %v\n", err)
+		fmt.Fprintf(os.Stderr, "failed to load private key: %v\n", err)
 		os.Exit(4)
 	}
 
-	//exp := time.Now().UTC().Add(time.Duration(param.days) * 24 * time.Hour).Unix()
-	exp := time.Now().UTC().Add(time.Duration(60) * time.Second).Unix() // 测试60秒过期
+	exp := time.Now().UTC().Add(time.Duration(param.days) * 24 * time.Hour).Unix()
 
 	// build payload (claims). Use explicit map to avoid ordering issues; jose will sign payload bytes.
 	payload := map[string]interface{}{
@@ -124,15 +193,81 @@ func main() {
 		"sub":         "license",
 		"customer":    param.customer,
 		"fingerprint": fp, // hex string
+		"product":     param.product,
+		"jti":         licenseID(),
 		"iat":         time.Now().UTC().Unix(),
 		"exp":         exp,
 	}
 
+	// bind the license to the outstanding offline activation request
+	if nonce != "" {
+		payload["nonce"] = nonce
+	}
+	// bind to the full digest; the truncated fingerprint is kept for display and lookups
+	if param.full != "" {
+		full := strings.ToLower(strings.TrimSpace(param.full))
+		if len(full) != 64 || !strings.HasPrefix(full, fp) {
+			fmt.Fprintln(os.Stderr, "full fingerprint must be 64 hex chars matching the fingerprint")
+			os.Exit(3)
+		}
+		payload["fingerprint_full"] = full
+	}
+	// fingerprint salt mode, so the verifier checks it uses the same salt
+	switch param.salt {
+	case "":
+	case "product", "customer":
+		payload["fp_salt"] = param.salt
+	default:
+		fmt.Fprintln(os.Stderr, "unsupported fingerprint salt: "+param.salt)
+		os.Exit(3)
+	}
+	// fingerprint scheme version, so the verifier recomputes the fingerprint the same way
+	if param.scheme != 0 {
+		payload["fp_scheme"] = param.scheme
+	}
+	// containers may bind to the host, the cluster or a persistent volume instead of the machine
+	if param.binding != "" && param.binding != "machine" {
+		payload["binding"] = param.binding
+	}
+	// virtual machine policy; vm-instance-uuid stops cloned VMs from sharing the license
+	switch param.vmPolicy {
+	case "":
+	case "physical-only", "vm-allowed":
+		payload["vm_policy"] = param.vmPolicy
+	case "vm-instance-uuid":
+		if param.instance == "" {
+			fmt.Fprintln(os.Stderr, "vm-instance-uuid policy requires -instance-uuid or -request")
+			os.Exit(2)
+		}
+		payload["vm_policy"] = param.vmPolicy
+		payload["instance_uuid"] = param.instance
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported vm policy: %s\n", param.vmPolicy)
+		os.Exit(2)
+	}
+	// features granted by the license, exposed to the application after verification
+	if param.features != "" {
+		var features []string
+		for _, f := range strings.Split(param.features, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				features = append(features, f)
+			}
+		}
+		payload["features"] = features
+	}
+	// per-component hashes allow the license to survive a hostname or NIC change;
+	// the fingerprint and full digest would still fail on any change, so they are bound instead of them
+	if len(components) > 0 && (param.binding == "" || param.binding == "machine") {
+		payload["fp_components"] = components
+		delete(payload, "fingerprint")
+		delete(payload, "fingerprint_full")
+	}
+
 	// optional meta
 	if param.metaStr != "" {
 		var metaObj map[string]interface{}
 		if err = json.Unmarshal([]byte(param.metaStr), &metaObj); err != nil {
-			fmt.Println(os.Stderr, "invalid meta json: %v\n", err)
+			fmt.Fprintf(os.Stderr, "invalid meta json: %v\n", err)
 			os.Exit(5)
 		}
 		payload["meta"] = metaObj
@@ -141,7 +276,7 @@ func main() {
 	// marshal payload
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		fmt.Println(os.Stderr, "json marshal payload error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "json marshal payload error: %v\n", err)
 		os.Exit(6)
 	}
 
@@ -149,24 +284,33 @@ func main() {
 	signerOpts := (&jose.SignerOptions{}).WithType("JWT")
 	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.PS256, Key: priv}, signerOpts)
 	if err != nil {
-		fmt.Println(os.Stderr, "failed to create signer: %v\n", err)
+		fmt.Fprintf(os.Stderr, "failed to create signer: %v\n", err)
 		os.Exit(7)
 	}
 
 	jws, err := signer.Sign(payloadBytes)
 	if err != nil {
-		fmt.Println(os.Stderr, "failed to sign payload: %v\n", err)
+		fmt.Fprintf(os.Stderr, "failed to sign payload: %v\n", err)
 		os.Exit(8)
 	}
 
 	compact, err := jws.CompactSerialize()
 	if err != nil {
-		fmt.Println(os.Stderr, "failed to serialize jws: %v\n", err)
+		fmt.Fprintf(os.Stderr, "failed to serialize jws: %v\n", err)
 		os.Exit(9)
 	}
+	if encKey != nil {
+		compact, err = license.EncryptLicense(compact, encKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(9)
+		}
+	}
 
-	if err = os.WriteFile(param.out, []byte(compact), 0600); err != nil {
-		fmt.Println(os.Stderr, "failed to write output: %v\n", err)
+	// 写入带校验和封装的许可证文件
+	content := verify.EncodeLicenseFile(compact, param.product)
+	if err = os.WriteFile(param.out, []byte(content), 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write output: %v\n", err)
 		os.Exit(10)
 	}
 
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -8,16 +11,44 @@ import (
 	"strings"
 	"time"
 
+	"license/hwid"
 	"license/internal/config"
 	"license/internal/database"
-	"license/internal/hwid"
 	"license/internal/license"
+	"license/middleware"
+	"license/verify"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// 产品指纹盐，配置后本服务的指纹无法与使用本库的其他产品关联
+	var salt []byte
+	if config.Conf.Hwid.Salt != "" {
+		var err error
+		if salt, err = hwid.DecodeSalt(config.Conf.Hwid.Salt); err != nil {
+			log.Fatalf("Invalid hwid salt: %v", err)
+		}
+	}
+	// 选择组成机器指纹的采集器
+	if err := hwid.Configure(hwid.Config{
+		Collectors:    config.Conf.Hwid.Collectors,
+		File:          config.Conf.Hwid.File,
+		Binding:       config.Conf.Hwid.Binding,
+		HostMachineID: config.Conf.Hwid.HostMachineID,
+		VolumeID:      config.Conf.Hwid.VolumeID,
+		Scheme:        config.Conf.Hwid.Scheme,
+		Salt:          salt,
+		Customer:      config.Conf.Hwid.Customer,
+	}); err != nil {
+		log.Fatalf("Failed to configure hwid collectors: %v", err)
+	}
+	// 容器中主机名和 machine-id 每次部署都可能变化
+	if ci := hwid.DetectContainer("/"); ci.InContainer && hwid.DefaultBinding() == hwid.BindingMachine {
+		log.Printf("Warning: running in %s container (%v), machine fingerprint may change on redeploy; consider hwid.binding", ci.Runtime, ci.Evidence)
+	}
+
 	// 初始化数据库
 	db, err := database.NewDB("license.db")
 	if err != nil {
@@ -61,6 +92,47 @@ func main() {
 	if _, err := os.Stat(storePath); os.IsNotExist(err) {
 		log.Fatalf("Private key file not found: %s", storePath)
 	}
+	// 创建许可证签发器（本地私钥文件或 PKCS#11 令牌），启动时校验私钥与公钥配对
+	issuer, err := license.NewIssuer(license.IssuerConfig{
+		SignerType:     config.Conf.SignerType,
+		PrivateKeyPath: privateKeyPath,
+		PublicKeyPath:  pubKeyPath,
+		PKCS11: license.PKCS11Config{
+			Module:     config.Conf.PKCS11.Module,
+			TokenLabel: config.Conf.PKCS11.TokenLabel,
+			Pin:        config.Conf.PKCS11.Pin,
+			KeyLabel:   config.Conf.PKCS11.KeyLabel,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize license issuer: %v", err)
+	}
+	defer issuer.Close()
+
+	// 加载产品配置
+	products := license.Products{}
+	for _, pc := range config.Conf.Products {
+		p, err := license.NewProduct(license.ProductConfig{
+			Name:            pc.Name,
+			ID:              pc.ID,
+			Encrypt:         pc.Encrypt,
+			EncryptionKey:   pc.EncryptionKey,
+			ShortKeySecret:  pc.ShortKeySecret,
+			FingerprintSalt: pc.FingerprintSalt,
+		})
+		if err != nil {
+			log.Fatalf("Invalid product config: %v", err)
+		}
+		products[p.Name] = p
+	}
+	selfProduct, err := products.Lookup(config.Conf.Product)
+	if err != nil {
+		log.Fatalf("Invalid product config: %v", err)
+	}
+
+	// 收到 SIGHUP 或密钥文件变更时热加载
+	issuer.WatchReload(5 * time.Second)
+
 	// 健康检查端点
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -74,14 +146,62 @@ func main() {
 	{
 		// 获取系统指纹
 		api.GET("/system/fingerprint", func(c *gin.Context) {
-			fingerprint := hwid.GetFingerprint()
+			// 可以通过 binding 参数获取其他绑定方式的指纹
+			binding := c.DefaultQuery("binding", hwid.DefaultBinding())
+			// scheme 参数指定指纹算法版本，用于按新算法重新激活
+			scheme := hwid.DefaultScheme()
+			if v := c.Query("scheme"); v != "" {
+				if scheme, err = strconv.Atoi(v); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scheme"})
+					return
+				}
+			}
+			fingerprint, err := hwid.FingerprintForScheme(binding, scheme)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			digest, err := hwid.FingerprintDigestFor(binding, scheme)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			saltMode, _ := hwid.Salting()
 			c.JSON(http.StatusOK, gin.H{
 				"fingerprint": fingerprint,
+				// 完整的 256 位指纹，签发绑定完整指纹的许可证时使用
+				"fullFingerprint": hex.EncodeToString(digest),
+				"binding":         binding,
+				"scheme":          scheme,
+				// 指纹的加盐方式，激活时原样提交，服务端据此确认与产品配置一致
+				"salt":      saltMode,
+				"container": hwid.DetectContainer("/"),
+				"vm":        hwid.DetectVM("/"),
+				// 签发 vm-instance-uuid 策略的许可证时使用
+				"instanceUuid": hwid.InstanceUUIDHash(),
 			})
 		})
 
+		// 指纹诊断：各采集器是否可用以及选中的网卡
+		api.GET("/system/hwid", func(c *gin.Context) {
+			c.JSON(http.StatusOK, hwid.Diagnose())
+		})
+
+		// 指纹组成说明，提交许可证时说明与本机哪些组件不一致
+		api.GET("/system/fingerprint/details", license.FingerprintDetailsHandler(issuer, products))
+		api.POST("/system/fingerprint/details", license.FingerprintDetailsHandler(issuer, products))
+
+		// 签名的当前时间，在线客户端用它判断许可证有效期
+		api.GET("/time", license.TimeHandler(issuer))
+
 		// 许可证激活端点
-		api.POST("/license/activate", license.ActivateHandler(pubKeyPath, privateKeyPath, db))
+		api.POST("/license/activate", license.ActivateHandler(issuer, products, db))
+
+		// 离线激活：上传激活请求文件，返回绑定该请求的许可证文件
+		api.POST("/license/offline-activate", license.OfflineActivateHandler(issuer, products, db))
+
+		// 签发短许可证密钥（离线机器电话录入）
+		api.POST("/license/shortkey", license.ShortKeyHandler(products))
 
 		// 获取所有许可证激活记录（支持分页和客户名称搜索）
 		api.GET("/license/activations", func(c *gin.Context) {
@@ -234,16 +354,41 @@ func main() {
 				return
 			}
 
-			// 返回许可证内容
+			// 返回带校验和封装的许可证文件内容
 			c.JSON(http.StatusOK, gin.H{
 				"success":        true,
-				"licenseContent": activation.License,
+				"licenseContent": verify.EncodeLicenseFile(activation.License, activation.Product),
 			})
 		})
 	}
 
 	// 应用许可证中间件到所有路由（除了健康检查和API路由组）
-	r.Use(license.LicenseMiddleware(pubKeyPath, storePath, db))
+	var allow []middleware.Rule
+	for _, a := range config.Conf.LicenseAllow {
+		allow = append(allow, middleware.Rule{Methods: a.Methods, Pattern: a.Path})
+	}
+	source, err := licenseSource(config.Conf.LicenseSources, db)
+	if err != nil {
+		log.Fatalf("Invalid license sources: %v", err)
+	}
+	clockSecret, err := licenseClockSecret(config.Conf.LicenseClockSecret)
+	if err != nil {
+		log.Fatalf("Invalid license clock secret: %v", err)
+	}
+	licenseMiddleware, closeLicense := license.LicenseMiddleware(license.LicenseMiddlewareConfig{
+		PublicKeyPath:  pubKeyPath,
+		StorePath:      storePath,
+		Product:        selfProduct,
+		DB:             db,
+		Source:         source,
+		Allow:          allow,
+		Interval:       time.Duration(config.Conf.LicenseRecheckSeconds) * time.Second,
+		ClockStatePath: config.Conf.LicenseClockState,
+		ClockSecret:    clockSecret,
+		StatusPath:     config.Conf.LicenseStatusPath,
+	})
+	defer closeLicense()
+	r.Use(licenseMiddleware)
 
 	// 启动服务器
 	port := "8080"
@@ -261,3 +406,39 @@ func main() {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// licenseClockSecret 时间记录密钥，环境变量 LICENSE_CLOCK_SECRET 优先于配置
+func licenseClockSecret(s string) ([]byte, error) {
+	if env := os.Getenv("LICENSE_CLOCK_SECRET"); env != "" {
+		s = env
+	}
+	if s == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// licenseSource 按配置组合许可证来源，未配置时返回 nil，使用中间件的默认来源
+func licenseSource(cfgs []config.SourceConfig, db *database.DB) (middleware.LicenseSource, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+	var chain middleware.Chain
+	for _, sc := range cfgs {
+		switch sc.Type {
+		case "database":
+			chain = append(chain, license.ActivationSource{DB: db})
+		case "file":
+			chain = append(chain, middleware.FileSource{Path: sc.Path})
+		case "dir":
+			chain = append(chain, middleware.DirSource{Dir: sc.Path})
+		case "env":
+			chain = append(chain, middleware.EnvSource{Name: sc.Name})
+		case "remote":
+			chain = append(chain, &middleware.RemoteSource{URL: sc.URL})
+		default:
+			return nil, fmt.Errorf("unsupported license source: %s", sc.Type)
+		}
+	}
+	return chain, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"license/hwid"
+	"license/internal/license"
+	"license/verify"
+)
+
+// 离线激活客户端：
+//
+//	offline_activate request -product license -out activation.req
+//	offline_activate accept -response license.lic -pub public.pem -out license.lic
+//
+// request 生成激活请求文件并在本地保存待处理请求，accept 只接受与待处理请求 nonce 一致的许可证
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "request":
+		request(os.Args[2:])
+	case "accept":
+		accept(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: offline_activate request|accept [flags]")
+	os.Exit(2)
+}
+
+func request(args []string) {
+	fs := flag.NewFlagSet("request", flag.ExitOnError)
+	product := fs.String("product", "", "product name")
+	out := fs.String("out", "activation.req", "activation request file sent to the vendor")
+	pending := fs.String("pending", "activation.pending", "local copy of the outstanding request")
+	binding := fs.String("binding", "", "fingerprint binding: machine, host-machine-id, k8s-cluster or volume")
+	salt := fs.String("salt", "", "base64 product fingerprint salt")
+	customer := fs.String("customer", "", "customer name for per-customer salting (requires -salt)")
+	fs.Parse(args)
+
+	// 产品加盐时，请求中的指纹与组件哈希都按产品盐计算
+	if *salt != "" {
+		key, err := hwid.DecodeSalt(*salt)
+		if err == nil {
+			err = hwid.Configure(hwid.Config{Salt: key, Customer: *customer})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid fingerprint salt: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	req, err := license.NewActivationRequest(*product, *binding)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create activation request: %v\n", err)
+		os.Exit(3)
+	}
+	content, err := license.EncodeActivationRequest(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode activation request: %v\n", err)
+		os.Exit(4)
+	}
+
+	// 待处理请求只保存在本机，用于校验返回的许可证
+	state, err := json.Marshal(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode pending request: %v\n", err)
+		os.Exit(4)
+	}
+	if err := os.WriteFile(*pending, state, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write pending request: %v\n", err)
+		os.Exit(5)
+	}
+	if err := os.WriteFile(*out, []byte(content), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write activation request: %v\n", err)
+		os.Exit(5)
+	}
+	fmt.Printf("activation request written to %s\n", *out)
+}
+
+func accept(args []string) {
+	fs := flag.NewFlagSet("accept", flag.ExitOnError)
+	response := fs.String("response", "", "license file returned by the vendor")
+	pubPath := fs.String("pub", "public.pem", "public key path")
+	key := fs.String("key", "", "base64 product encryption key, for encrypted licenses")
+	pending := fs.String("pending", "activation.pending", "local copy of the outstanding request")
+	out := fs.String("out", "license.lic", "installed license file")
+	fs.Parse(args)
+
+	if *response == "" {
+		fmt.Fprintln(os.Stderr, "response is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	state, err := os.ReadFile(*pending)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "no outstanding activation request: %v\n", err)
+		os.Exit(3)
+	}
+	var req license.ActivationRequest
+	if err := json.Unmarshal(state, &req); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid pending request: %v\n", err)
+		os.Exit(3)
+	}
+
+	pub, err := verify.LoadPublicKey(*pubPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load public key: %v\n", err)
+		os.Exit(4)
+	}
+	product, err := license.NewProduct(license.ProductConfig{Name: req.Product, EncryptionKey: *key})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid encryption key: %v\n", err)
+		os.Exit(4)
+	}
+
+	b, err := os.ReadFile(*response)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read response: %v\n", err)
+		os.Exit(5)
+	}
+	token, err := license.AcceptActivationResponse(pub, product.EncryptionKey, &req, string(b))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "license rejected: %v\n", err)
+		os.Exit(6)
+	}
+
+	if err := os.WriteFile(*out, []byte(verify.EncodeLicenseFile(token, req.Product)), 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write license: %v\n", err)
+		os.Exit(7)
+	}
+	os.Remove(*pending)
+	fmt.Printf("license installed to %s\n", *out)
+}
@@ -1,7 +1,13 @@
 module license-dll
 
-go 1.21
+go 1.24.0
 
-require github.com/square/go-jose/v3 v3.0.0-20200630053402-0a67ce9b0693
+require license v0.0.0
 
-require golang.org/x/crypto v0.0.0-20190911031432-227b76d455e7 // indirect
+require (
+	github.com/square/go-jose/v3 v3.0.0-20200630053402-0a67ce9b0693 // indirect
+	golang.org/x/crypto v0.40.0 // indirect
+)
+
+// 指纹和许可证校验核心与服务端共用
+replace license => ../..
@@ -9,32 +9,29 @@ package main
 
 // 导出函数声明
 extern char* GenerateFingerprint();
+extern char* GenerateFingerprintComponents();
 extern int VerifyLicense(char* publicKeyPath, char* licenseContent);
 extern char* GetLicenseData(char* publicKeyPath, char* licenseContent);
+extern int VerifyShortKey(char* shortKey);
+extern char* GetShortKeyData(char* shortKey);
 extern void FreeString(char* str);
 */
 import "C"
 
 import (
 	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/x509"
-	"encoding/base32"
-	"encoding/hex"
+	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net"
 	"os"
-	"os/exec"
-	"runtime"
-	"strings"
+	"path/filepath"
+	"sync"
 	"time"
 	"unsafe"
 
-	"github.com/square/go-jose/v3"
+	"license/hwid"
+	"license/verify"
 )
 
 // 定义错误码
@@ -45,256 +42,273 @@ const (
 	ErrorLicenseExpired
 	ErrorFingerprintMismatch
 	ErrorInternal
+	ErrorVMNotAllowed
+	ErrorClockTampered
 )
 
+// 本产品的名称，构建时通过 -ldflags "-X main.productName=..." 注入，
+// 注入后拒绝签发给其他产品的许可证
+var productName string
+
+// 产品内置的许可证解密密钥（base64 编码的 32 字节），构建时通过
+// -ldflags "-X main.productEncryptionKey=..." 注入，用于解密加密许可证（JWE）
+var productEncryptionKey string
+
+// 产品内置的短许可证密钥 HMAC 密钥（base64 编码），构建时通过
+// -ldflags "-X main.productShortKeySecret=..." 注入
+var productShortKeySecret string
+
+// 产品内置的时间记录密钥，构建时通过 -ldflags "-X main.productClockSecret=..." 注入，
+// 为空时使用 productEncryptionKey 或 productShortKeySecret，都没有时使用时间记录旁边的安装密钥
+var productClockSecret string
+
+// 产品内置的指纹盐（base64 编码，至少 16 字节），构建时通过
+// -ldflags "-X main.productFingerprintSalt=..." 注入。注入后指纹和组件哈希改为 HMAC-SHA256，
+// 与其他产品的指纹无法关联；按客户加盐时客户名称由环境变量 LICENSE_FP_CUSTOMER 提供
+var productFingerprintSalt string
+
 // 定义许可证数据结构
 type LicenseData struct {
-	Issuer      string `json:"issuer"`
-	Customer    string `json:"customer"`
-	Fingerprint string `json:"fingerprint"`
-	IssuedAt    int64  `json:"issuedAt"`
-	ExpiresAt   int64  `json:"expiresAt"`
+	Issuer      string   `json:"issuer"`
+	Customer    string   `json:"customer"`
+	Fingerprint string   `json:"fingerprint"`
+	Product     string   `json:"product,omitempty"`
+	Features    []string `json:"features,omitempty"`
+	IssuedAt    int64    `json:"issuedAt"`
+	ExpiresAt   int64    `json:"expiresAt"`
 }
 
-// 获取机器ID
-func getMachineID() string {
-	switch runtime.GOOS {
-	case "linux":
-		// Linux系统
-		if b, err := ioutil.ReadFile("/etc/machine-id"); err == nil {
-			return strings.TrimSpace(string(b))
-		}
-		// 尝试其他可能的位置
-		if b, err := ioutil.ReadFile("/var/lib/dbus/machine-id"); err == nil {
-			return strings.TrimSpace(string(b))
-		}
-	case "darwin":
-		// macOS系统
-		if b, err := ioutil.ReadFile("/etc/hostid"); err == nil {
-			return strings.TrimSpace(string(b))
-		}
-		// 尝试使用系统命令获取
-		if output, err := runCommand("ioreg", "-rd1", "-c", "IOPlatformExpertDevice"); err == nil {
-			lines := strings.Split(output, "\n")
-			for _, line := range lines {
-				if strings.Contains(line, "IOPlatformUUID") {
-					parts := strings.Split(line, `"`)
-					if len(parts) >= 4 {
-						return strings.TrimSpace(parts[3])
-					}
-				}
-			}
-		}
-	case "windows":
-		// Windows系统
-		if output, err := runCommand("wmic", "csproduct", "get", "UUID"); err == nil {
-			lines := strings.Split(output, "\n")
-			for _, line := range lines {
-				if strings.TrimSpace(line) != "" && !strings.Contains(line, "UUID") {
-					return strings.TrimSpace(line)
-				}
-			}
-		}
-	}
-	return ""
-}
+// 共享库与服务端、命令行工具共用 license/hwid 和 license/verify，同一台机器上得到相同的指纹和校验结果
 
-// 运行系统命令
-func runCommand(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", err
-	}
-	return string(output), nil
-}
+// 初始化指纹配置时的错误，例如注入的指纹盐无效
+var configErr error
 
-// 获取主网卡MAC地址
-func getPrimaryMAC() string {
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		return ""
+func init() {
+	// 宿主机 machine-id 和持久卷标识的路径可以通过环境变量 LICENSE_HOST_MACHINE_ID、LICENSE_VOLUME_ID 修改
+	// 旧激活码（SchemeLegacy）的 machine-id 按共享库最初的规则读取，保证已签发的许可证有效
+	cfg := hwid.Config{
+		LegacyPerOS:   true,
+		HostMachineID: os.Getenv("LICENSE_HOST_MACHINE_ID"),
+		VolumeID:      os.Getenv("LICENSE_VOLUME_ID"),
 	}
-	for _, iface := range ifaces {
-		if iface.Flags&net.FlagLoopback != 0 {
-			continue
+	if productFingerprintSalt != "" {
+		salt, err := hwid.DecodeSalt(productFingerprintSalt)
+		if err != nil {
+			configErr = err
+			return
 		}
-		if len(iface.HardwareAddr) == 0 {
-			continue
-		}
-		return iface.HardwareAddr.String()
+		cfg.Salt = salt
+		cfg.Customer = os.Getenv("LICENSE_FP_CUSTOMER")
 	}
-	return ""
+	configErr = hwid.Configure(cfg)
 }
 
-// 获取主机名
-func getHostname() string {
-	hn, err := os.Hostname()
-	if err != nil {
-		return ""
+// 生成机器指纹
+func getFingerprint() (string, error) {
+	if configErr != nil {
+		return "", configErr
 	}
-	return hn
+	return hwid.FingerprintFor(hwid.BindingMachine)
 }
 
-// 生成机器指纹
-func getFingerprint() string {
-	mid := getMachineID()
-	mac := getPrimaryMAC()
-	hn := getHostname()
-
-	// 组合信息，SHA256 生成指纹
-	combined := mid + "|" + mac + "|" + hn
-	h := sha256.Sum256([]byte(combined))
-	hexFP := hex.EncodeToString(h[:])
-	code, err := toActivationCodeFromHex(hexFP)
-	if err != nil {
-		return ""
+// 解码构建时注入的 base64 密钥，未注入时返回 nil
+func embeddedKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
 	}
-	return code
+	return base64.StdEncoding.DecodeString(s)
 }
 
-// 将十六进制字符串转换为激活码格式
-func toActivationCodeFromHex(hexStr string) (string, error) {
-	b, err := hex.DecodeString(strings.TrimSpace(hexStr))
-	if err != nil {
-		return "", err
+// 时钟回拨检测：时间记录默认保存在用户配置目录的 license/clock.json，
+// 可以通过环境变量 LICENSE_CLOCK_STATE 修改，无法确定路径时不检测；
+// 副本保存在用户缓存目录，删除其中一份会按另一份恢复；
+// 时间记录的 HMAC 密钥由构建时嵌入的产品密钥派生
+func clockGuard() (*verify.ClockGuard, error) {
+	path := os.Getenv("LICENSE_CLOCK_STATE")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(dir, "license", "clock.json")
+	}
+	cfg := verify.ClockGuardConfig{Path: path}
+	for _, secret := range []string{productClockSecret, productEncryptionKey, productShortKeySecret} {
+		if secret != "" {
+			cfg.Key = verify.DeriveClockKey([]byte(secret))
+			break
+		}
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		cfg.BackupPath = filepath.Join(dir, "license", "clock.json")
 	}
-	return toActivationCodeFromBytes(b)
+	return verify.NewClockGuard(cfg)
 }
 
-// 将字节数组转换为激活码格式
-func toActivationCodeFromBytes(b []byte) (string, error) {
-	const targetBytes = 10 // 80 bits -> 16 base32 chars
-	buf := make([]byte, targetBytes)
-	if len(b) >= targetBytes {
-		copy(buf, b[:targetBytes])
-	} else {
-		copy(buf, b)
-		// 若不足 10 字节，后面已是 0 补齐（可接受）
-	}
-
-	// 使用标准 base32 (RFC4648) 大写，无 padding
-	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
-	s := enc.EncodeToString(buf) // 16 chars for 10 bytes
-	if len(s) != 16 {
-		return "", errors.New("unexpected encoded length")
-	}
-	// 格式化为 4-4-4-4
-	parts := []string{s[0:4], s[4:8], s[8:12], s[12:16]}
-	return strings.Join(parts, "-"), nil
-}
+// 每个公钥路径的 Verifier 在进程内复用，可信时间在 Verifier 中缓存，
+// 否则每次调用 VerifyLicense 都要重新请求 LICENSE_TIME_URL
+var (
+	verifierMu sync.Mutex
+	verifiers  = map[string]*verify.Verifier{}
+)
 
-// 将激活码解码为十六进制字符串
-func decodeActivationCodeToHex(code string) (string, error) {
-	s := strings.ToUpper(strings.ReplaceAll(strings.ReplaceAll(code, "-", ""), " ", ""))
-	if len(s) != 16 {
-		return "", fmt.Errorf("activation code must be 16 base32 chars")
+// cachedVerifier 返回公钥路径对应的 Verifier，第一次调用时加载公钥和时钟回拨检测；
+// 创建失败时不缓存，下次调用重试
+func cachedVerifier(publicKeyPath string) (*verify.Verifier, int, error) {
+	verifierMu.Lock()
+	defer verifierMu.Unlock()
+	if v, ok := verifiers[publicKeyPath]; ok {
+		return v, Success, nil
 	}
-	b, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+
+	// 加载公钥
+	pub, err := verify.LoadPublicKey(publicKeyPath)
 	if err != nil {
-		return "", err
+		return nil, ErrorInvalidPublicKey, err
 	}
-	return hex.EncodeToString(b), nil
-}
 
-// 加载公钥
-func loadPublicKey(path string) (*rsa.PublicKey, error) {
-	b, err := os.ReadFile(path)
+	// 加密许可证（JWE）先用内置密钥解密
+	key, err := embeddedKey(productEncryptionKey)
 	if err != nil {
-		return nil, err
+		return nil, ErrorInternal, err
 	}
-	block, _ := pem.Decode(b)
-	if block == nil {
-		return nil, errors.New("invalid pem")
+	guard, err := clockGuard()
+	if errors.Is(err, verify.ErrClockTampered) {
+		return nil, ErrorClockTampered, err
 	}
-	pubIface, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
-		// try rsa pub1
-		rpub, err2 := x509.ParsePKCS1PublicKey(block.Bytes)
-		if err2 == nil {
-			return rpub, nil
-		}
-		return nil, err
+		return nil, ErrorInternal, err
 	}
-	if pub, ok := pubIface.(*rsa.PublicKey); ok {
-		return pub, nil
+	// 环境变量 LICENSE_TIME_URL 指向许可证服务器的 /api/time 时按签名时间判断有效期，
+	// 无法连接时回退到本机时间
+	var trusted verify.TimeSource
+	if u := os.Getenv("LICENSE_TIME_URL"); u != "" {
+		trusted = verify.RemoteTime{URL: u}
 	}
-	return nil, errors.New("not rsa pub")
-}
-
-// 定义许可证claims结构
-type claims struct {
-	Iss         string `json:"iss"`
-	Sub         string `json:"sub"`
-	Customer    string `json:"customer"`
-	Fingerprint string `json:"fingerprint"`
-	Iat         int64  `json:"iat"`
-	Exp         int64  `json:"exp"`
+	v, err := verify.NewVerifier(verify.VerifierConfig{
+		PublicKeys:    []*rsa.PublicKey{pub},
+		EncryptionKey: key,
+		Product:       productName,
+		// 嵌入了解密密钥说明产品按加密签发，不接受未加密的许可证
+		RequireEncrypted: key != nil,
+		ClockGuard:       guard,
+		TrustedTime:      trusted,
+	})
+	if err != nil {
+		return nil, ErrorInvalidPublicKey, err
+	}
+	verifiers[publicKeyPath] = v
+	return v, Success, nil
 }
 
 // 验证许可证
 func verifyLicense(publicKeyPath, licenseContent string) (int, *LicenseData, error) {
-	// 加载公钥
-	pub, err := loadPublicKey(publicKeyPath)
-	if err != nil {
-		return ErrorInvalidPublicKey, nil, err
+	if configErr != nil {
+		return ErrorInternal, nil, configErr
 	}
 
-	// 解析JWS
-	signed, err := jose.ParseSigned(licenseContent)
+	// 加载公钥和校验器，同一个公钥路径在进程内复用
+	v, code, err := cachedVerifier(publicKeyPath)
 	if err != nil {
-		return ErrorInvalidLicense, nil, err
+		return code, nil, err
 	}
 
-	// 验证签名
-	out, err := signed.Verify(pub)
+	// 解析许可证文件封装
+	lf, err := verify.DecodeLicenseFile(licenseContent)
 	if err != nil {
 		return ErrorInvalidLicense, nil, err
 	}
 
-	// 解析claims
-	var c claims
-	if err := json.Unmarshal(out, &c); err != nil {
+	// 验证签名、过期时间、指纹和虚拟机策略
+	l, err := v.VerifyToken(lf.Token)
+	switch {
+	case err == nil:
+	case errors.Is(err, verify.ErrExpired):
+		return ErrorLicenseExpired, nil, err
+	case errors.Is(err, verify.ErrFingerprintMismatch):
+		return ErrorFingerprintMismatch, nil, err
+	case errors.Is(err, verify.ErrVMNotAllowed):
+		return ErrorVMNotAllowed, nil, err
+	case errors.Is(err, verify.ErrClockTampered):
+		return ErrorClockTampered, nil, err
+	default:
 		return ErrorInvalidLicense, nil, err
 	}
 
-	// 检查过期时间
-	if time.Now().UTC().Unix() > c.Exp {
-		return ErrorLicenseExpired, nil, errors.New("license expired")
+	// 返回许可证数据
+	licenseData := &LicenseData{
+		Issuer:      l.Issuer,
+		Customer:    l.Customer,
+		Fingerprint: l.Fingerprint,
+		Product:     l.Product,
+		Features:    l.Features,
+		IssuedAt:    l.IssuedAt.Unix(),
+		ExpiresAt:   l.ExpiresAt.Unix(),
+	}
+
+	return Success, licenseData, nil
+}
+
+// 短许可证密钥数据
+type ShortKeyData struct {
+	ProductID uint16 `json:"productId"`
+	ExpiresAt int64  `json:"expiresAt"`
+	Features  uint16 `json:"features"`
+}
+
+// 验证短许可证密钥
+func verifyShortKey(code string) (int, *ShortKeyData, error) {
+	secret, err := embeddedKey(productShortKeySecret)
+	if err != nil || len(secret) == 0 {
+		return ErrorInternal, nil, errors.New("short key secret is not embedded")
 	}
 
 	// 获取本机指纹
-	localFingerprint := getFingerprint()
-	localHex, err := decodeActivationCodeToHex(localFingerprint)
+	fp, err := getFingerprint()
 	if err != nil {
 		return ErrorInternal, nil, err
 	}
-
-	// 比较指纹
-	if c.Fingerprint != localHex {
-		return ErrorFingerprintMismatch, nil, errors.New("fingerprint mismatch")
+	localHex, err := hwid.DecodeActivationCodeToHex(fp)
+	if err != nil {
+		return ErrorInternal, nil, err
 	}
 
-	// 返回许可证数据
-	licenseData := &LicenseData{
-		Issuer:      c.Iss,
-		Customer:    c.Customer,
-		Fingerprint: c.Fingerprint,
-		IssuedAt:    c.Iat,
-		ExpiresAt:   c.Exp,
+	k, err := verify.VerifyShortKey(secret, code, localHex, time.Now().UTC())
+	if errors.Is(err, verify.ErrExpired) {
+		return ErrorLicenseExpired, nil, err
 	}
-
-	return Success, licenseData, nil
+	if err != nil {
+		return ErrorInvalidLicense, nil, err
+	}
+	return Success, &ShortKeyData{ProductID: k.ProductID, ExpiresAt: k.Expiry.Unix(), Features: k.Features}, nil
 }
 
 // 导出函数：生成机器指纹
 //
 //export GenerateFingerprint
 func GenerateFingerprint() *C.char {
-	fingerprint := getFingerprint()
+	fingerprint, err := getFingerprint()
+	if err != nil {
+		return C.CString("")
+	}
 	return C.CString(fingerprint)
 }
 
+// 导出函数：生成本机各指纹组件的哈希（JSON格式），作为激活接口的 components 字段
+// 签发按组件模糊匹配的许可证
+//
+//export GenerateFingerprintComponents
+func GenerateFingerprintComponents() *C.char {
+	if configErr != nil {
+		return C.CString(fmt.Sprintf(`{"error": "%s"}`, configErr.Error()))
+	}
+	jsonData, err := json.Marshal(hwid.GetComponents())
+	if err != nil {
+		return C.CString(fmt.Sprintf(`{"error": "%s"}`, err.Error()))
+	}
+	return C.CString(string(jsonData))
+}
+
 // 导出函数：验证许可证
 //
 //export VerifyLicense
@@ -334,6 +348,29 @@ func GetLicenseData(publicKeyPath, licenseContent *C.char) *C.char {
 	return C.CString(string(jsonData))
 }
 
+// 导出函数：验证短许可证密钥
+//
+//export VerifyShortKey
+func VerifyShortKey(shortKey *C.char) C.int {
+	code, _, _ := verifyShortKey(C.GoString(shortKey))
+	return C.int(code)
+}
+
+// 导出函数：获取短许可证密钥数据（JSON格式）
+//
+//export GetShortKeyData
+func GetShortKeyData(shortKey *C.char) *C.char {
+	_, data, err := verifyShortKey(C.GoString(shortKey))
+	if err != nil {
+		return C.CString(fmt.Sprintf(`{"error": "%s"}`, err.Error()))
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return C.CString(fmt.Sprintf(`{"error": "%s"}`, err.Error()))
+	}
+	return C.CString(string(jsonData))
+}
+
 // 导出函数：释放字符串内存
 //
 //export FreeString
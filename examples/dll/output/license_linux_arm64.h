@@ -29,8 +29,11 @@ typedef struct { const char *p; ptrdiff_t n; } _GoString_;
 
 // 导出函数声明
 extern char* GenerateFingerprint();
+extern char* GenerateFingerprintComponents();
 extern int VerifyLicense(char* publicKeyPath, char* licenseContent);
 extern char* GetLicenseData(char* publicKeyPath, char* licenseContent);
+extern int VerifyShortKey(char* shortKey);
+extern char* GetShortKeyData(char* shortKey);
 extern void FreeString(char* str);
 
 #line 1 "cgo-generated-wrapper"
@@ -94,6 +97,11 @@ extern "C" {
 //
 extern char* GenerateFingerprint();
 
+// 导出函数：生成本机各指纹组件的哈希（JSON格式），作为激活接口的 components 字段
+// 签发按组件模糊匹配的许可证
+//
+extern char* GenerateFingerprintComponents();
+
 // 导出函数：验证许可证
 //
 extern int VerifyLicense(char* publicKeyPath, char* licenseContent);
@@ -102,6 +110,14 @@ extern int VerifyLicense(char* publicKeyPath, char* licenseContent);
 //
 extern char* GetLicenseData(char* publicKeyPath, char* licenseContent);
 
+// 导出函数：验证短许可证密钥
+//
+extern int VerifyShortKey(char* shortKey);
+
+// 导出函数：获取短许可证密钥数据（JSON格式）
+//
+extern char* GetShortKeyData(char* shortKey);
+
 // 导出函数：释放字符串内存
 //
 extern void FreeString(char* str);
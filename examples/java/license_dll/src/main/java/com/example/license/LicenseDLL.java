@@ -76,6 +76,8 @@ public interface LicenseDLL extends Library {
      *         3: 许可证已过期
      *         4: 指纹不匹配
      *         5: 内部错误
+     *         6: 许可证不允许在虚拟机中运行
+     *         7: 检测到系统时间被回拨
      */
     int VerifyLicense(String publicKeyPath, String licenseContent);
     
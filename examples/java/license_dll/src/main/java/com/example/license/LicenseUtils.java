@@ -100,6 +100,14 @@ public class LicenseUtils {
                 result.setSuccess(false);
                 result.setMessage("内部错误");
                 break;
+            case 6:
+                result.setSuccess(false);
+                result.setMessage("许可证不允许在虚拟机中运行");
+                break;
+            case 7:
+                result.setSuccess(false);
+                result.setMessage("检测到系统时间被回拨");
+                break;
             default:
                 result.setSuccess(false);
                 result.setMessage("未知错误");
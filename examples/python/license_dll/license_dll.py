@@ -143,7 +143,9 @@ class LicenseDLL:
             2: "Invalid license",
             3: "License expired",
             4: "Fingerprint mismatch",
-            5: "Internal error"
+            5: "Internal error",
+            6: "Virtual machine not allowed",
+            7: "System clock rolled back"
         }
         
         message = messages.get(result_code, f"Unknown error code: {result_code}")
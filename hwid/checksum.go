@@ -0,0 +1,131 @@
+package hwid
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ------------------ 激活码校验位 ------------------
+
+// 激活码通过电话等方式转述时容易抄错，在 16 个 base32 字符后追加一个 Luhn mod 32 校验位：
+// 能发现任意单个字符错误和绝大多数相邻字符颠倒。校验已签发的许可证时仍然接受不带校验位的旧激活码，
+// 激活时必须带校验位
+
+const codeAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// 容易混淆的字符：base32 中没有 0、1、8，直接替换；其余为形近字符，只用于给出修正建议
+var (
+	codeReplacer = strings.NewReplacer("0", "O", "1", "I", "8", "B")
+	confusables  = []string{"ODQ", "IJLT", "S5", "Z2", "G6C", "UV", "MN", "B3E"}
+)
+
+// ErrNoCheckChar 激活码没有校验位，激活时不接受，否则抄漏一个字符也无法发现
+var ErrNoCheckChar = errors.New("activation code has no check char")
+
+// ChecksumError 激活码校验位不匹配，Suggestions 为可能的正确激活码
+type ChecksumError struct {
+	Code        string
+	Suggestions []string
+}
+
+func (e *ChecksumError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("activation code %s failed checksum, please check for typos", e.Code)
+	}
+	return fmt.Sprintf("activation code %s failed checksum, did you mean %s?", e.Code, strings.Join(e.Suggestions, " or "))
+}
+
+// NormalizeActivationCode 转大写、去掉空白，并把 0/1/8 纠正为 O/I/B
+func NormalizeActivationCode(code string) string {
+	s := strings.ToUpper(strings.Join(strings.Fields(code), ""))
+	return codeReplacer.Replace(s)
+}
+
+// checkChar 计算 Luhn mod 32 校验位
+func checkChar(data string) byte {
+	const n = len(codeAlphabet)
+	factor, sum := 2, 0
+	for i := len(data) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(codeAlphabet, data[i])
+		if factor == 2 {
+			factor = 1
+		} else {
+			factor = 2
+		}
+		sum += addend/n + addend%n
+	}
+	return codeAlphabet[(n-sum%n)%n]
+}
+
+// VerifyCheckChar 校验 16 个数据字符加校验位（已去掉连字符），只有 16 个字符的旧激活码直接通过，
+// 用于校验已有的许可证；激活时使用 RequireCheckChar
+func VerifyCheckChar(s string) error {
+	switch len(s) {
+	case 16:
+		return nil
+	case 17:
+	default:
+		return errors.New("activation code must be 16 base32 chars plus a check char")
+	}
+	data, check := s[:16], s[16]
+	if checkChar(data) == check {
+		return nil
+	}
+	return &ChecksumError{Code: formatCode(data, check), Suggestions: suggestCodes(data, check)}
+}
+
+// RequireCheckChar 与 VerifyCheckChar 相同，但不接受没有校验位的旧激活码
+func RequireCheckChar(s string) error {
+	if len(s) == 16 {
+		return ErrNoCheckChar
+	}
+	return VerifyCheckChar(s)
+}
+
+// suggestCodes 尝试相邻字符颠倒和形近字符替换，返回能通过校验的激活码
+func suggestCodes(data string, check byte) []string {
+	full := []byte(data + string(check))
+	seen := map[string]bool{}
+	var out []string
+	try := func(b []byte) {
+		if checkChar(string(b[:16])) != b[16] {
+			return
+		}
+		code := formatCode(string(b[:16]), b[16])
+		if !seen[code] {
+			seen[code] = true
+			out = append(out, code)
+		}
+	}
+
+	for i := 0; i+1 < len(full); i++ {
+		b := append([]byte(nil), full...)
+		b[i], b[i+1] = b[i+1], b[i]
+		try(b)
+	}
+	for i := range full {
+		for _, group := range confusables {
+			if strings.IndexByte(group, full[i]) < 0 {
+				continue
+			}
+			for j := 0; j < len(group); j++ {
+				if group[j] == full[i] {
+					continue
+				}
+				b := append([]byte(nil), full...)
+				b[i] = group[j]
+				try(b)
+			}
+		}
+	}
+	// 候选太多说明无法判断，不给建议
+	if len(out) > 3 {
+		return nil
+	}
+	return out
+}
+
+func formatCode(data string, check byte) string {
+	return strings.Join([]string{data[0:4], data[4:8], data[8:12], data[12:16], string(check)}, "-")
+}
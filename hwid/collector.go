@@ -0,0 +1,592 @@
+package hwid
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ------------------ Collector ------------------
+
+// Collector 硬件标识采集器，每个采集器对应指纹中的一个组件
+type Collector interface {
+	// Name 组件名称，同时作为配置中的标识和组件哈希的键
+	Name() string
+	// Collect 返回组件原始值，无法获取时返回错误
+	Collect() (string, error)
+}
+
+// SchemeCollector 可选接口，采集器在某个指纹算法版本下的取值规则与 Collect 不同时实现，
+// 例如 SchemeLegacy 必须保持最初的取值规则，否则已签发的许可证会失效
+type SchemeCollector interface {
+	CollectScheme(scheme int) (string, error)
+}
+
+// collectFor 按指纹算法版本获取组件原始值
+func collectFor(c Collector, scheme int) (string, error) {
+	if sc, ok := c.(SchemeCollector); ok {
+		return sc.CollectScheme(scheme)
+	}
+	return c.Collect()
+}
+
+// Describer 可选接口，说明采集器的取值来源（文件路径、网卡名称等），供诊断使用，不包含原始值
+type Describer interface {
+	Describe() string
+}
+
+// SchemeDescriber 可选接口，实现 SchemeCollector 的采集器说明某个指纹算法版本下的取值来源
+type SchemeDescriber interface {
+	DescribeScheme(scheme int) string
+}
+
+// describeFor 按指纹算法版本说明采集器的取值来源，不支持时返回空
+func describeFor(c Collector, scheme int) string {
+	if sd, ok := c.(SchemeDescriber); ok {
+		return sd.DescribeScheme(scheme)
+	}
+	if ds, ok := c.(Describer); ok {
+		return ds.Describe()
+	}
+	return ""
+}
+
+// 附加组件名称
+const (
+	ComponentDMIUUID    = "dmi-uuid"
+	ComponentDiskSerial = "disk-serial"
+	ComponentCPU        = "cpu"
+	ComponentTPMEK      = "tpm-ek"
+	ComponentFile       = "file"
+)
+
+// DefaultCollectors 默认组成指纹的采集器，顺序决定指纹的组合方式，改动会使已有许可证失效
+var DefaultCollectors = []string{ComponentMachineID, ComponentMAC, ComponentHostname}
+
+var (
+	mu        sync.RWMutex
+	registry  = map[string]Collector{}
+	active    []Collector
+	errNoData = errors.New("no data")
+)
+
+func init() {
+	Register(MachineIDCollector{Root: "/"})
+	Register(MACCollector{Root: "/"})
+	Register(HostnameCollector{})
+	Register(DMIUUIDCollector{Root: "/"})
+	Register(DiskSerialCollector{Root: "/"})
+	Register(CPUCollector{Root: "/"})
+	Register(TPMEKCollector{})
+	active = mustLookup(DefaultCollectors)
+}
+
+// Register 注册采集器，同名采集器会被替换
+func Register(c Collector) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[c.Name()] = c
+}
+
+// Lookup 按名称查找已注册的采集器
+func Lookup(names []string) ([]Collector, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	cs := make([]Collector, 0, len(names))
+	for _, name := range names {
+		c, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown hwid collector: %s", name)
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+func mustLookup(names []string) []Collector {
+	cs, err := Lookup(names)
+	if err != nil {
+		panic(err)
+	}
+	return cs
+}
+
+// Config 指纹采集配置
+type Config struct {
+	Collectors []string // 组成指纹的采集器，为空时使用 DefaultCollectors
+	File       string   // file 采集器读取的文件路径
+
+	Binding       string // 本机默认的许可证绑定方式，为空时为 machine
+	HostMachineID string // host-machine-id 绑定读取的路径，为空时为 DefaultHostMachineIDPath
+	VolumeID      string // volume 绑定的实例标识文件，为空时为 DefaultVolumeIDPath
+	Scheme        int    // 生成激活码使用的指纹算法版本，为 0 时为 SchemeLegacy
+	// LegacyPerOS SchemeLegacy 的 machine-id 按共享库最初的规则读取，只有共享库设置，
+	// 否则共享库在 Windows、macOS 上签发的旧许可证无法通过校验
+	LegacyPerOS bool
+
+	Salt     []byte // 产品指纹盐，配置后组件和指纹改为 HMAC-SHA256，为空时不加盐
+	Customer string // 按客户加盐时的客户名称，需要同时配置 Salt
+}
+
+var (
+	defaultBinding = BindingMachine
+	defaultScheme  = SchemeLegacy
+	saltKey        []byte
+	saltMode       = SaltNone
+	saltCustomer   string
+)
+
+// Configure 按配置选择组成指纹的采集器
+func Configure(cfg Config) error {
+	Register(MachineIDCollector{Root: "/", LegacyPerOS: cfg.LegacyPerOS})
+	if cfg.File != "" {
+		Register(FileCollector{Path: cfg.File})
+	}
+	if cfg.HostMachineID != "" {
+		Register(HostMachineIDCollector{Path: cfg.HostMachineID})
+	}
+	if cfg.VolumeID != "" {
+		Register(VolumeCollector{Path: cfg.VolumeID})
+	}
+	if !ValidBinding(cfg.Binding) {
+		return fmt.Errorf("unsupported binding: %s", cfg.Binding)
+	}
+	if cfg.Scheme != 0 && !ValidScheme(cfg.Scheme) {
+		return fmt.Errorf("unsupported fingerprint scheme: %d", cfg.Scheme)
+	}
+	if cfg.Customer != "" && cfg.Salt == nil {
+		return errors.New("per-customer fingerprint salt requires a product salt")
+	}
+	names := cfg.Collectors
+	if len(names) == 0 {
+		names = DefaultCollectors
+	}
+	cs, err := Lookup(names)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	active = cs
+	defaultBinding = BindingMachine
+	if cfg.Binding != "" {
+		defaultBinding = cfg.Binding
+	}
+	defaultScheme = SchemeLegacy
+	if cfg.Scheme != 0 {
+		defaultScheme = cfg.Scheme
+	}
+	saltKey, saltMode, saltCustomer = nil, SaltNone, ""
+	if cfg.Salt != nil {
+		saltKey, saltMode = deriveKey(cfg.Salt, cfg.Customer), SaltProduct
+		if cfg.Customer != "" {
+			saltMode, saltCustomer = SaltCustomer, cfg.Customer
+		}
+	}
+	mu.Unlock()
+	return nil
+}
+
+// DefaultScheme 生成激活码默认使用的指纹算法版本
+func DefaultScheme() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultScheme
+}
+
+// DefaultBinding 本机默认的许可证绑定方式
+func DefaultBinding() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultBinding
+}
+
+// Active 当前组成指纹的采集器
+func Active() []Collector {
+	mu.RLock()
+	defer mu.RUnlock()
+	return active
+}
+
+// ------------------ 内置采集器 ------------------
+
+// 文件类采集器都带 Root，便于指向伪造的 /sys、/proc 目录树
+
+func readTrimmed(root, path string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(root, path))
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return "", errNoData
+	}
+	return s, nil
+}
+
+// MachineIDCollector 读取系统的机器标识：Linux 为 /etc/machine-id（或 /var/lib/dbus/machine-id），
+// macOS 为 /etc/hostid 或 IOPlatformUUID，Windows 为 SMBIOS 中的产品 UUID；
+// SchemeLegacy 保持最初的取值规则：服务端只读取 /etc/machine-id，共享库（LegacyPerOS）按操作系统读取
+type MachineIDCollector struct {
+	Root string
+	// LegacyPerOS SchemeLegacy 按共享库最初的规则读取：macOS 为 /etc/hostid 或 IOPlatformUUID，
+	// Windows 为 wmic 产品 UUID，Linux 的 /etc/machine-id 不存在时回退到 /var/lib/dbus/machine-id
+	LegacyPerOS bool
+}
+
+// runCommand 执行系统命令，测试时可以替换
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+func (MachineIDCollector) Name() string { return ComponentMachineID }
+
+func (c MachineIDCollector) Describe() string {
+	switch runtime.GOOS {
+	case "darwin":
+		if _, err := readTrimmed(c.Root, "etc/hostid"); err == nil {
+			return filepath.Join(c.Root, "etc/hostid")
+		}
+		return "ioreg IOPlatformUUID"
+	case "windows":
+		return "wmic csproduct UUID"
+	}
+	if _, err := readTrimmed(c.Root, "etc/machine-id"); err == nil {
+		return filepath.Join(c.Root, "etc/machine-id")
+	}
+	return filepath.Join(c.Root, "var/lib/dbus/machine-id")
+}
+
+func (c MachineIDCollector) DescribeScheme(scheme int) string {
+	if scheme != SchemeLegacy {
+		return c.Describe()
+	}
+	if c.LegacyPerOS {
+		switch runtime.GOOS {
+		case "linux":
+			if _, err := os.Stat(filepath.Join(c.Root, "etc/machine-id")); err != nil {
+				return filepath.Join(c.Root, "var/lib/dbus/machine-id")
+			}
+		case "darwin", "windows":
+			return c.Describe()
+		}
+	}
+	return filepath.Join(c.Root, "etc/machine-id")
+}
+
+func (c MachineIDCollector) Collect() (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		if id, err := readTrimmed(c.Root, "etc/hostid"); err == nil {
+			return id, nil
+		}
+		return platformUUID()
+	case "windows":
+		return productUUID()
+	}
+	if id, err := readTrimmed(c.Root, "etc/machine-id"); err == nil {
+		return id, nil
+	}
+	return readTrimmed(c.Root, "var/lib/dbus/machine-id")
+}
+
+func (c MachineIDCollector) CollectScheme(scheme int) (string, error) {
+	if scheme != SchemeLegacy {
+		return c.Collect()
+	}
+	if c.LegacyPerOS {
+		return c.legacyPerOS(runtime.GOOS)
+	}
+	return readTrimmed(c.Root, "etc/machine-id")
+}
+
+// legacyPerOS 共享库最初的 machine-id 规则：文件存在即使用，即使内容为空也不回退
+func (c MachineIDCollector) legacyPerOS(goos string) (string, error) {
+	var paths []string
+	switch goos {
+	case "linux":
+		paths = []string{"etc/machine-id", "var/lib/dbus/machine-id"}
+	case "darwin":
+		paths = []string{"etc/hostid"}
+	case "windows":
+		return productUUID()
+	default:
+		return "", errNoData
+	}
+	for _, p := range paths {
+		if b, err := os.ReadFile(filepath.Join(c.Root, p)); err == nil {
+			if id := strings.TrimSpace(string(b)); id != "" {
+				return id, nil
+			}
+			return "", errNoData
+		}
+	}
+	if goos == "darwin" {
+		return platformUUID()
+	}
+	return "", errNoData
+}
+
+// platformUUID macOS 的 IOPlatformUUID
+func platformUUID() (string, error) {
+	out, err := runCommand("ioreg", "-rd1", "-c", "IOPlatformExpertDevice")
+	if err != nil {
+		return "", fmt.Errorf("ioreg: %v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		// "IOPlatformUUID" = "XXXXXXXX-XXXX-..."
+		if parts := strings.Split(line, `"`); strings.Contains(line, "IOPlatformUUID") && len(parts) >= 4 {
+			return strings.TrimSpace(parts[3]), nil
+		}
+	}
+	return "", errNoData
+}
+
+// productUUID Windows 的 SMBIOS 产品 UUID
+func productUUID() (string, error) {
+	out, err := runCommand("wmic", "csproduct", "get", "UUID")
+	if err != nil {
+		return "", fmt.Errorf("wmic: %v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.Contains(line, "UUID") {
+			return line, nil
+		}
+	}
+	return "", errNoData
+}
+
+// MACCollector 按 SelectMAC 的策略选择网卡的 MAC 地址；
+// SchemeLegacy 取首个非回环网卡，与最初的指纹算法一致
+type MACCollector struct{ Root string }
+
+func (MACCollector) Name() string { return ComponentMAC }
+
+func (c MACCollector) Describe() string {
+	if sel, err := SelectMAC(c.Root); err == nil && sel.Interface != "" {
+		return "interface " + sel.Interface
+	}
+	return "no usable interface"
+}
+
+func (c MACCollector) DescribeScheme(scheme int) string {
+	if scheme != SchemeLegacy {
+		return c.Describe()
+	}
+	if iface, err := firstInterface(); err == nil {
+		return "interface " + iface.Name + " (first non-loopback)"
+	}
+	return "no usable interface"
+}
+
+func (c MACCollector) Collect() (string, error) {
+	sel, err := SelectMAC(c.Root)
+	if err != nil {
+		return "", err
+	}
+	if sel.Interface == "" {
+		return "", errNoData
+	}
+	return sel.Address, nil
+}
+
+func (c MACCollector) CollectScheme(scheme int) (string, error) {
+	if scheme == SchemeLegacy {
+		return FirstMAC()
+	}
+	return c.Collect()
+}
+
+// HostnameCollector 获取主机名
+type HostnameCollector struct{}
+
+func (HostnameCollector) Name() string { return ComponentHostname }
+
+func (HostnameCollector) Describe() string { return "os.Hostname" }
+
+func (HostnameCollector) Collect() (string, error) {
+	return os.Hostname()
+}
+
+// DMIUUIDCollector 读取主板 DMI 产品 UUID，通常需要 root 权限
+type DMIUUIDCollector struct{ Root string }
+
+func (DMIUUIDCollector) Name() string { return ComponentDMIUUID }
+
+func (c DMIUUIDCollector) Describe() string {
+	return filepath.Join(c.Root, "sys/class/dmi/id/product_uuid")
+}
+
+func (c DMIUUIDCollector) Collect() (string, error) {
+	s, err := readTrimmed(c.Root, "sys/class/dmi/id/product_uuid")
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(s), nil
+}
+
+// DiskSerialCollector 读取所有物理磁盘的序列号，排序后组合
+type DiskSerialCollector struct{ Root string }
+
+func (DiskSerialCollector) Name() string { return ComponentDiskSerial }
+
+func (c DiskSerialCollector) Describe() string {
+	return filepath.Join(c.Root, "sys/block/*/serial")
+}
+
+func (c DiskSerialCollector) Collect() (string, error) {
+	devs, err := os.ReadDir(filepath.Join(c.Root, "sys/block"))
+	if err != nil {
+		return "", err
+	}
+	var serials []string
+	for _, d := range devs {
+		name := d.Name()
+		// 跳过虚拟块设备
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") ||
+			strings.HasPrefix(name, "dm-") || strings.HasPrefix(name, "zram") {
+			continue
+		}
+		// NVMe 和多数 SCSI/SATA 驱动的序列号在 device/serial，virtio 等在 serial
+		for _, p := range []string{"device/serial", "serial"} {
+			if s, err := readTrimmed(c.Root, filepath.Join("sys/block", name, p)); err == nil {
+				serials = append(serials, s)
+				break
+			}
+		}
+	}
+	if len(serials) == 0 {
+		return "", errNoData
+	}
+	sort.Strings(serials)
+	return strings.Join(serials, ","), nil
+}
+
+// CPUCollector 读取 /proc/cpuinfo 中首个处理器的厂商和型号
+type CPUCollector struct{ Root string }
+
+func (CPUCollector) Name() string { return ComponentCPU }
+
+func (c CPUCollector) Describe() string { return filepath.Join(c.Root, "proc/cpuinfo") }
+
+func (c CPUCollector) Collect() (string, error) {
+	b, err := os.ReadFile(filepath.Join(c.Root, "proc/cpuinfo"))
+	if err != nil {
+		return "", err
+	}
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		// 只取第一个处理器块
+		if strings.TrimSpace(line) == "" && len(fields) > 0 {
+			break
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	var parts []string
+	for _, k := range []string{"vendor_id", "cpu family", "model", "model name", "CPU implementer", "CPU part"} {
+		if v := fields[k]; v != "" {
+			parts = append(parts, v)
+		}
+	}
+	if len(parts) == 0 {
+		return "", errNoData
+	}
+	return strings.Join(parts, "|"), nil
+}
+
+// TPMEKCollector 读取 TPM 背书密钥（EK）公钥的哈希，依赖 tpm2-tools
+type TPMEKCollector struct {
+	Handle string // EK 持久句柄，默认 0x81010001
+}
+
+func (TPMEKCollector) Name() string { return ComponentTPMEK }
+
+func (c TPMEKCollector) Describe() string {
+	if c.Handle == "" {
+		return "tpm2_readpublic -c 0x81010001"
+	}
+	return "tpm2_readpublic -c " + c.Handle
+}
+
+func (c TPMEKCollector) Collect() (string, error) {
+	handle := c.Handle
+	if handle == "" {
+		handle = "0x81010001"
+	}
+	// 输出中的 name 是 EK 公钥区域的哈希，可以直接作为组件值
+	out, err := exec.Command("tpm2_readpublic", "-c", handle).Output()
+	if err != nil {
+		return "", fmt.Errorf("tpm2_readpublic: %v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(k) == "name" {
+			return strings.TrimSpace(v), nil
+		}
+	}
+	return "", errNoData
+}
+
+// FileCollector 读取自定义文件内容，例如部署时写入的资产编号
+type FileCollector struct{ Path string }
+
+func (FileCollector) Name() string { return ComponentFile }
+
+func (c FileCollector) Describe() string { return c.Path }
+
+func (c FileCollector) Collect() (string, error) {
+	return readTrimmed("", c.Path)
+}
+
+// ------------------ 诊断 ------------------
+
+// CollectorStatus 单个采集器的采集情况，不包含原始值
+type CollectorStatus struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Diagnostics 指纹诊断信息，用于排查指纹变化的原因
+type Diagnostics struct {
+	Fingerprint string            `json:"fingerprint"`
+	Error       string            `json:"error,omitempty"` // 按绑定方式计算指纹失败的原因
+	Binding     string            `json:"binding"`
+	Scheme      int               `json:"scheme"`
+	Salt        string            `json:"salt,omitempty"` // 加盐方式，不包含盐本身
+	Collectors  []CollectorStatus `json:"collectors"`
+	MAC         *MACSelection     `json:"mac,omitempty"`
+	Container   ContainerInfo     `json:"container"`
+	VM          VMInfo            `json:"vm"`
+}
+
+// Diagnose 收集当前指纹的组成情况以及网卡选择结果
+func Diagnose() *Diagnostics {
+	d := &Diagnostics{Binding: DefaultBinding(), Scheme: DefaultScheme(), Container: DetectContainer("/"), VM: DetectVM("/")}
+	d.Salt, _ = Salting()
+	fp, err := FingerprintFor(d.Binding)
+	if err != nil {
+		d.Error = err.Error()
+	}
+	d.Fingerprint = fp
+	for _, c := range Active() {
+		st := CollectorStatus{Name: c.Name(), Available: true}
+		if _, err := collectFor(c, d.Scheme); err != nil {
+			st.Available = false
+			st.Error = err.Error()
+		}
+		d.Collectors = append(d.Collectors, st)
+		if mc, ok := c.(MACCollector); ok {
+			d.MAC, _ = SelectMAC(mc.Root)
+		}
+	}
+	return d
+}
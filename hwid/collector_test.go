@@ -0,0 +1,414 @@
+package hwid
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeTree 在 root 下按相对路径写入文件，用于伪造 /etc、/sys、/proc 目录树
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMachineIDCollector(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("machine-id files are only read on linux")
+	}
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "etc machine-id",
+			files: map[string]string{"etc/machine-id": "abc123\n", "var/lib/dbus/machine-id": "dbus456\n"},
+			want:  "abc123",
+		},
+		{
+			name:  "dbus fallback",
+			files: map[string]string{"var/lib/dbus/machine-id": "dbus456\n"},
+			want:  "dbus456",
+		},
+		{
+			name:  "empty etc machine-id falls back",
+			files: map[string]string{"etc/machine-id": "  \n", "var/lib/dbus/machine-id": "dbus456"},
+			want:  "dbus456",
+		},
+		{
+			name:    "missing",
+			files:   map[string]string{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, tt.files)
+			got, err := MachineIDCollector{Root: root}.Collect()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Collect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Collect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMachineIDCollectorLegacyScheme(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"var/lib/dbus/machine-id": "dbus456"})
+	c := MachineIDCollector{Root: root}
+	// SchemeLegacy 只读取 /etc/machine-id，dbus 回退会改变已签发许可证的指纹
+	if got, err := c.CollectScheme(SchemeLegacy); err == nil {
+		t.Fatalf("CollectScheme(SchemeLegacy) = %q, want error", got)
+	}
+
+	writeTree(t, root, map[string]string{"etc/machine-id": "abc123\n"})
+	got, err := c.CollectScheme(SchemeLegacy)
+	if err != nil || got != "abc123" {
+		t.Fatalf("CollectScheme(SchemeLegacy) = %q, %v, want abc123", got, err)
+	}
+}
+
+// 共享库的 SchemeLegacy 按操作系统读取 machine-id，结果必须与共享库最初的实现一致
+func TestMachineIDCollectorLegacyPerOS(t *testing.T) {
+	orig := runCommand
+	defer func() { runCommand = orig }()
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		switch name {
+		case "ioreg":
+			return []byte(`+-o Mac  <class IOPlatformExpertDevice>` + "\n" +
+				`    "IOPlatformUUID" = "564D1A2B-0000-1111-2222-333344445555"` + "\n"), nil
+		case "wmic":
+			return []byte("UUID                                  \r\n4C4C4544-0042-3510-8052-B4C04F4A4E32  \r\n\r\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	tests := []struct {
+		goos    string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{goos: "linux", files: map[string]string{"etc/machine-id": "abc123\n", "var/lib/dbus/machine-id": "dbus456"}, want: "abc123"},
+		{goos: "linux", files: map[string]string{"var/lib/dbus/machine-id": "dbus456\n"}, want: "dbus456"},
+		// 最初的实现只要 /etc/machine-id 存在就不回退
+		{goos: "linux", files: map[string]string{"etc/machine-id": "\n", "var/lib/dbus/machine-id": "dbus456"}, wantErr: true},
+		{goos: "linux", files: map[string]string{}, wantErr: true},
+		{goos: "darwin", files: map[string]string{"etc/hostid": "hostid789\n"}, want: "hostid789"},
+		{goos: "darwin", files: map[string]string{}, want: "564D1A2B-0000-1111-2222-333344445555"},
+		{goos: "windows", files: map[string]string{"etc/machine-id": "abc123"}, want: "4C4C4544-0042-3510-8052-B4C04F4A4E32"},
+		{goos: "plan9", files: map[string]string{"etc/machine-id": "abc123"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goos, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, tt.files)
+			got, err := MachineIDCollector{Root: root, LegacyPerOS: true}.legacyPerOS(tt.goos)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("legacyPerOS(%s) error = %v, wantErr %v", tt.goos, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("legacyPerOS(%s) = %q, want %q", tt.goos, got, tt.want)
+			}
+		})
+	}
+}
+
+type fixedCollector struct{ name, value string }
+
+func (c fixedCollector) Name() string             { return c.name }
+func (c fixedCollector) Collect() (string, error) { return c.value, nil }
+
+// SchemeLegacy 的指纹为 sha256(machine-id|MAC|主机名)，与最初的服务端和共享库相同
+func TestMachineDigestLegacy(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"etc/machine-id": "abc123\n"})
+	orig := Active()
+	defer func() { mu.Lock(); active = orig; mu.Unlock() }()
+	mu.Lock()
+	active = []Collector{
+		MachineIDCollector{Root: root, LegacyPerOS: runtime.GOOS == "linux"},
+		fixedCollector{ComponentMAC, "02:42:ac:11:00:02"},
+		fixedCollector{ComponentHostname, "host1"},
+	}
+	mu.Unlock()
+
+	digest, err := MachineDigest(SchemeLegacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.Sum256([]byte("abc123|02:42:ac:11:00:02|host1"))
+	if !bytes.Equal(digest, want[:]) {
+		t.Errorf("MachineDigest(SchemeLegacy) = %x, want %x", digest, want)
+	}
+}
+
+func TestDMIUUIDCollector(t *testing.T) {
+	root := t.TempDir()
+	c := DMIUUIDCollector{Root: root}
+	if _, err := c.Collect(); err == nil {
+		t.Fatal("Collect() without product_uuid should fail")
+	}
+
+	writeTree(t, root, map[string]string{"sys/class/dmi/id/product_uuid": "4C4C4544-0042-3510-8052-B4C04F4A4E32\n"})
+	got, err := c.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "4c4c4544-0042-3510-8052-b4c04f4a4e32"; got != want {
+		t.Errorf("Collect() = %q, want %q", got, want)
+	}
+}
+
+func TestDiskSerialCollector(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "sorted serials",
+			files: map[string]string{
+				"sys/block/sdb/device/serial":     "WD-2222\n",
+				"sys/block/nvme0n1/device/serial": "S4EWNX0N1111",
+				"sys/block/vda/serial":            "virtio-3333",
+			},
+			want: "S4EWNX0N1111,WD-2222,virtio-3333",
+		},
+		{
+			name: "device serial preferred",
+			files: map[string]string{
+				"sys/block/sda/device/serial": "DEVICE",
+				"sys/block/sda/serial":        "BLOCK",
+			},
+			want: "DEVICE",
+		},
+		{
+			name: "virtual devices skipped",
+			files: map[string]string{
+				"sys/block/loop0/serial":    "LOOP",
+				"sys/block/ram0/serial":     "RAM",
+				"sys/block/dm-0/serial":     "DM",
+				"sys/block/zram0/serial":    "ZRAM",
+				"sys/block/sda/device/type": "0",
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no sys block",
+			files:   map[string]string{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, tt.files)
+			got, err := DiskSerialCollector{Root: root}.Collect()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Collect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Collect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCPUCollector(t *testing.T) {
+	tests := []struct {
+		name    string
+		cpuinfo string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "x86 first processor only",
+			cpuinfo: "processor\t: 0\nvendor_id\t: GenuineIntel\ncpu family\t: 6\nmodel\t\t: 158\n" +
+				"model name\t: Intel(R) Core(TM) i7-8700 CPU @ 3.20GHz\nflags\t\t: fpu vme\n\n" +
+				"processor\t: 1\nvendor_id\t: AuthenticAMD\n",
+			want: "GenuineIntel|6|158|Intel(R) Core(TM) i7-8700 CPU @ 3.20GHz",
+		},
+		{
+			name:    "arm",
+			cpuinfo: "processor\t: 0\nBogoMIPS\t: 50.00\nCPU implementer\t: 0x41\nCPU part\t: 0xd0c\n",
+			want:    "0x41|0xd0c",
+		},
+		{
+			name:    "no known fields",
+			cpuinfo: "processor\t: 0\nBogoMIPS\t: 50.00\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, map[string]string{"proc/cpuinfo": tt.cpuinfo})
+			got, err := CPUCollector{Root: root}.Collect()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Collect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Collect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileCollector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asset")
+	if err := os.WriteFile(path, []byte("ASSET-0001\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := FileCollector{Path: path}.Collect()
+	if err != nil || got != "ASSET-0001" {
+		t.Fatalf("Collect() = %q, %v, want ASSET-0001", got, err)
+	}
+}
+
+func TestClassifyIface(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"sys/class/net/eth0/device/vendor":     "0x8086",
+		"sys/class/net/eth0/addr_assign_type":  "0",
+		"sys/class/net/wlan0/addr_assign_type": "3",
+	})
+	mac := net.HardwareAddr{0x02, 0x42, 0xac, 0x11, 0x00, 0x02}
+	tests := []struct {
+		iface        net.Interface
+		physical     bool
+		permanent    bool
+		wantExcluded bool
+	}{
+		{net.Interface{Name: "eth0", HardwareAddr: mac}, true, true, false},
+		{net.Interface{Name: "wlan0", HardwareAddr: mac}, false, false, false},
+		{net.Interface{Name: "docker0", HardwareAddr: mac}, false, false, true},
+		{net.Interface{Name: "lo", Flags: net.FlagLoopback}, false, false, true},
+		{net.Interface{Name: "tun0"}, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.iface.Name, func(t *testing.T) {
+			c := classifyIface(root, tt.iface)
+			if c.Physical != tt.physical || c.Permanent != tt.permanent || (c.Excluded != "") != tt.wantExcluded {
+				t.Errorf("classifyIface(%s) = %+v", tt.iface.Name, c)
+			}
+		})
+	}
+}
+
+func TestDetectVM(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("dmi and /proc are only read on linux")
+	}
+	tests := []struct {
+		name        string
+		files       map[string]string
+		virtual     bool
+		hypervisor  string
+		unavailable bool
+	}{
+		{
+			name: "physical",
+			files: map[string]string{
+				"sys/class/dmi/id/sys_vendor":   "Dell Inc.",
+				"sys/class/dmi/id/product_name": "PowerEdge R740",
+				"proc/cpuinfo":                  "processor\t: 0\nflags\t\t: fpu vme sse2\n",
+			},
+		},
+		{
+			name: "vmware dmi",
+			files: map[string]string{
+				"sys/class/dmi/id/sys_vendor":   "VMware, Inc.",
+				"sys/class/dmi/id/product_name": "VMware Virtual Platform",
+			},
+			virtual:    true,
+			hypervisor: "vmware",
+		},
+		{
+			name:       "hyper-v dmi",
+			files:      map[string]string{"sys/class/dmi/id/product_name": "Virtual Machine"},
+			virtual:    true,
+			hypervisor: "hyperv",
+		},
+		{
+			name:    "cpuid hypervisor bit",
+			files:   map[string]string{"proc/cpuinfo": "processor\t: 0\nflags\t\t: fpu vme hypervisor\n"},
+			virtual: true,
+		},
+		{
+			name:       "xen sys hypervisor",
+			files:      map[string]string{"sys/hypervisor/type": "xen\n"},
+			virtual:    true,
+			hypervisor: "xen",
+		},
+		{
+			name:        "nothing readable",
+			files:       map[string]string{},
+			unavailable: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, tt.files)
+			got := DetectVM(root)
+			if got.Virtual != tt.virtual || got.Hypervisor != tt.hypervisor {
+				t.Errorf("DetectVM() = %+v, want virtual %v hypervisor %q", got, tt.virtual, tt.hypervisor)
+			}
+			if (got.Unavailable != "") != tt.unavailable {
+				t.Errorf("DetectVM().Unavailable = %q, want unavailable %v", got.Unavailable, tt.unavailable)
+			}
+			if got.Virtual && len(got.Evidence) == 0 {
+				t.Error("DetectVM() reported a virtual machine without evidence")
+			}
+		})
+	}
+}
+
+func TestLookupUnknownCollector(t *testing.T) {
+	if _, err := Lookup([]string{ComponentMachineID, "no-such-collector"}); err == nil {
+		t.Fatal("Lookup() with an unknown collector should fail")
+	}
+}
+
+// Explain 按指纹算法版本说明来源：SchemeLegacy 只读取 /etc/machine-id，不回退到 dbus
+func TestExplainScheme(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("machine-id files are only read on linux")
+	}
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"var/lib/dbus/machine-id": "dbus456"})
+	orig := Active()
+	defer func() { mu.Lock(); active = orig; mu.Unlock() }()
+	mu.Lock()
+	active = []Collector{MachineIDCollector{Root: root}}
+	mu.Unlock()
+
+	legacy := Explain(BindingMachine, SchemeLegacy).Components[0]
+	if legacy.Source != filepath.Join(root, "etc/machine-id") || legacy.Error == "" || legacy.Hash != "" {
+		t.Errorf("Explain(SchemeLegacy) machine-id = %+v", legacy)
+	}
+	v2 := Explain(BindingMachine, SchemeV2).Components[0]
+	if v2.Source != filepath.Join(root, "var/lib/dbus/machine-id") || v2.Hash != hashComponent(ComponentMachineID, "dbus456") {
+		t.Errorf("Explain(SchemeV2) machine-id = %+v", v2)
+	}
+}
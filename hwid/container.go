@@ -0,0 +1,240 @@
+package hwid
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ------------------ 容器检测 ------------------
+
+// ContainerInfo 容器检测结果
+type ContainerInfo struct {
+	InContainer bool     `json:"inContainer"`
+	Runtime     string   `json:"runtime,omitempty"` // docker、podman、containerd、kubernetes
+	Evidence    []string `json:"evidence,omitempty"`
+}
+
+// DetectContainer 检测当前进程是否运行在容器中，root 为 / 所在的根目录
+func DetectContainer(root string) ContainerInfo {
+	info := ContainerInfo{}
+	found := func(runtime, evidence string) {
+		info.InContainer = true
+		if info.Runtime == "" || runtime == "kubernetes" {
+			info.Runtime = runtime
+		}
+		info.Evidence = append(info.Evidence, evidence)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, ".dockerenv")); err == nil {
+		found("docker", "/.dockerenv")
+	}
+	if _, err := os.Stat(filepath.Join(root, "run/.containerenv")); err == nil {
+		found("podman", "/run/.containerenv")
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "proc/1/cgroup")); err == nil {
+		cg := string(b)
+		switch {
+		case strings.Contains(cg, "kubepods"):
+			found("kubernetes", "cgroup kubepods")
+		case strings.Contains(cg, "docker"):
+			found("docker", "cgroup docker")
+		case strings.Contains(cg, "libpod"):
+			found("podman", "cgroup libpod")
+		case strings.Contains(cg, "containerd"):
+			found("containerd", "cgroup containerd")
+		}
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		found("kubernetes", "KUBERNETES_SERVICE_HOST")
+	}
+	return info
+}
+
+// ------------------ 绑定方式 ------------------
+
+// 许可证绑定方式。容器每次重新部署主机名和 machine-id 都可能变化，
+// 因此容器中的许可证可以改为绑定宿主机、Kubernetes 集群或持久卷
+const (
+	BindingMachine       = "machine"         // 本机硬件指纹（默认）
+	BindingHostMachineID = "host-machine-id" // 挂载进容器的宿主机 /etc/machine-id
+	BindingK8sCluster    = "k8s-cluster"     // Kubernetes 集群（kube-system 命名空间 UID）和所在命名空间
+	BindingVolume        = "volume"          // 持久卷中的实例标识文件
+)
+
+// 容器绑定的默认路径
+const (
+	DefaultHostMachineIDPath = "/host/etc/machine-id"
+	DefaultVolumeIDPath      = "/var/lib/license/instance-id"
+	k8sServiceAccountDir     = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+func init() {
+	Register(HostMachineIDCollector{Path: DefaultHostMachineIDPath})
+	Register(K8sClusterCollector{})
+	Register(VolumeCollector{Path: DefaultVolumeIDPath})
+}
+
+// ValidBinding 判断绑定方式是否受支持，空值等同于 machine
+func ValidBinding(binding string) bool {
+	switch binding {
+	case "", BindingMachine, BindingHostMachineID, BindingK8sCluster, BindingVolume:
+		return true
+	}
+	return false
+}
+
+// FingerprintFor 按绑定方式和默认算法版本计算激活码，machine 与 GetFingerprint 相同
+func FingerprintFor(binding string) (string, error) {
+	return FingerprintForScheme(binding, DefaultScheme())
+}
+
+// FingerprintForScheme 按绑定方式和指定算法版本计算激活码，算法版本只影响 machine 绑定
+func FingerprintForScheme(binding string, scheme int) (string, error) {
+	digest, err := FingerprintDigestFor(binding, scheme)
+	if err != nil {
+		return "", err
+	}
+	if binding == "" || binding == BindingMachine {
+		return FormatActivationCode(scheme, digest)
+	}
+	return ToActivationCodeFromBytes(digest)
+}
+
+// FingerprintDigestFor 按绑定方式和指定算法版本计算完整的 256 位指纹
+func FingerprintDigestFor(binding string, scheme int) ([]byte, error) {
+	if binding == "" || binding == BindingMachine {
+		return MachineDigest(scheme)
+	}
+	if !ValidBinding(binding) {
+		return nil, fmt.Errorf("unsupported binding: %s", binding)
+	}
+	cs, err := Lookup([]string{binding})
+	if err != nil {
+		return nil, err
+	}
+	v, err := cs[0].Collect()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", binding, err)
+	}
+	return sum(binding + "|" + v), nil
+}
+
+// HostMachineIDCollector 读取挂载进容器的宿主机 machine-id，
+// 例如 docker run -v /etc/machine-id:/host/etc/machine-id:ro
+type HostMachineIDCollector struct{ Path string }
+
+func (HostMachineIDCollector) Name() string { return BindingHostMachineID }
+
+func (c HostMachineIDCollector) Describe() string { return c.Path }
+
+func (c HostMachineIDCollector) Collect() (string, error) {
+	return readTrimmed("", c.Path)
+}
+
+// VolumeCollector 读取持久卷中的实例标识，不存在时生成，卷保留则重新部署后标识不变
+type VolumeCollector struct{ Path string }
+
+func (VolumeCollector) Name() string { return BindingVolume }
+
+func (c VolumeCollector) Describe() string { return c.Path }
+
+func (c VolumeCollector) Collect() (string, error) {
+	if id, err := readTrimmed("", c.Path); err == nil {
+		return id, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return "", err
+	}
+	// O_EXCL 避免多个副本同时生成不同的标识
+	f, err := os.OpenFile(c.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return readTrimmed("", c.Path)
+		}
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(id + "\n"); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// K8sClusterCollector 通过 ServiceAccount 读取 kube-system 命名空间的 UID 作为集群标识，
+// 与 Pod 所在命名空间组合，需要 RBAC 允许 get namespaces/kube-system
+type K8sClusterCollector struct{}
+
+func (K8sClusterCollector) Name() string { return BindingK8sCluster }
+
+func (K8sClusterCollector) Describe() string { return "kube-system namespace uid + pod namespace" }
+
+func (K8sClusterCollector) Collect() (string, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return "", errors.New("not running in kubernetes")
+	}
+	namespace, err := readTrimmed(k8sServiceAccountDir, "namespace")
+	if err != nil {
+		return "", err
+	}
+	token, err := readTrimmed(k8sServiceAccountDir, "token")
+	if err != nil {
+		return "", err
+	}
+	ca, err := os.ReadFile(filepath.Join(k8sServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return "", err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return "", errors.New("invalid service account ca.crt")
+	}
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://"+net.JoinHostPort(host, port)+"/api/v1/namespaces/kube-system", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("kubernetes api returned %s", resp.Status)
+	}
+	var ns struct {
+		Metadata struct {
+			UID string `json:"uid"`
+		} `json:"metadata"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ns); err != nil {
+		return "", err
+	}
+	if ns.Metadata.UID == "" {
+		return "", errNoData
+	}
+	return ns.Metadata.UID + "/" + namespace, nil
+}
@@ -0,0 +1,64 @@
+package hwid
+
+import "encoding/hex"
+
+// ------------------ 指纹说明 ------------------
+
+// ComponentDetail 单个组件的来源和哈希，不包含原始值
+type ComponentDetail struct {
+	Name   string `json:"name"`
+	Source string `json:"source,omitempty"`
+	Hash   string `json:"hash,omitempty"` // 与 GetComponents 相同的组件哈希
+	Error  string `json:"error,omitempty"`
+}
+
+// Explanation 本机指纹的组成说明，用于排查 fingerprint mismatch
+type Explanation struct {
+	Binding         string            `json:"binding"`
+	Scheme          int               `json:"scheme"`
+	Salt            string            `json:"salt,omitempty"`
+	Fingerprint     string            `json:"fingerprint"`
+	FullFingerprint string            `json:"fullFingerprint"`
+	Error           string            `json:"error,omitempty"`
+	Components      []ComponentDetail `json:"components"`
+}
+
+// Explain 说明按指定绑定方式和算法版本计算的本机指纹由哪些组件组成，
+// machine 绑定列出当前采集器，其他绑定方式列出对应的采集器
+func Explain(binding string, scheme int) *Explanation {
+	if binding == "" {
+		binding = BindingMachine
+	}
+	if scheme == 0 {
+		scheme = SchemeLegacy
+	}
+	e := &Explanation{Binding: binding, Scheme: scheme}
+	e.Salt, _ = Salting()
+
+	cs := Active()
+	if binding != BindingMachine {
+		cs, _ = Lookup([]string{binding})
+	}
+	for _, c := range cs {
+		// 按指纹算法版本说明来源并计算哈希，SchemeLegacy 的取值规则与新版本不同
+		d := ComponentDetail{Name: c.Name(), Source: describeFor(c, scheme)}
+		// 绑定方式的摘要与组件哈希的计算方式相同
+		if v, err := collectFor(c, scheme); err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Hash = hashComponent(c.Name(), v)
+		}
+		e.Components = append(e.Components, d)
+	}
+
+	fp, err := FingerprintForScheme(binding, scheme)
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	e.Fingerprint = fp
+	if digest, err := FingerprintDigestFor(binding, scheme); err == nil {
+		e.FullFingerprint = hex.EncodeToString(digest)
+	}
+	return e
+}
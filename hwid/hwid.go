@@ -0,0 +1,262 @@
+package hwid
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ------------------ 指纹算法版本 ------------------
+
+// 指纹算法版本。激活码和许可证都记录版本，改动组合方式时旧许可证仍按原算法校验
+const (
+	// SchemeLegacy 各组件按配置顺序用 | 连接后 SHA256，激活码不带版本前缀
+	SchemeLegacy = 1
+	// SchemeV2 组件名参与哈希并按名称排序，调整采集器顺序不影响指纹，激活码带 "V2-" 前缀
+	SchemeV2 = 2
+
+	LatestScheme = SchemeV2
+)
+
+// ValidScheme 判断指纹算法版本是否受支持
+func ValidScheme(scheme int) bool {
+	return scheme >= SchemeLegacy && scheme <= LatestScheme
+}
+
+// GetFingerprint 按配置的采集器和默认算法版本组合机器指纹，默认仅绑定物理机（machine-id、MAC、主机名）
+func GetFingerprint() string {
+	code, err := MachineFingerprint(DefaultScheme())
+	if err != nil {
+		panic(err)
+	}
+	return code
+}
+
+// MachineFingerprint 按指定算法版本生成本机激活码，用于校验旧版本算法签发的许可证
+func MachineFingerprint(scheme int) (string, error) {
+	digest, err := MachineDigest(scheme)
+	if err != nil {
+		return "", err
+	}
+	return FormatActivationCode(scheme, digest)
+}
+
+// MachineDigest 按指定算法版本计算本机完整的 256 位指纹，激活码只保留其中前 80 位
+func MachineDigest(scheme int) ([]byte, error) {
+	type component struct{ name, value string }
+	var comps []component
+	for _, c := range Active() {
+		// 获取失败的组件按空值参与组合
+		v, _ := collectFor(c, scheme)
+		comps = append(comps, component{c.Name(), v})
+	}
+
+	var combined string
+	switch scheme {
+	case SchemeLegacy:
+		// 与采集器的顺序一起决定指纹
+		values := make([]string, 0, len(comps))
+		for _, c := range comps {
+			values = append(values, c.value)
+		}
+		combined = strings.Join(values, "|")
+	case SchemeV2:
+		sort.Slice(comps, func(i, j int) bool { return comps[i].name < comps[j].name })
+		var sb strings.Builder
+		sb.WriteString("license-fingerprint-v2\n")
+		for _, c := range comps {
+			sb.WriteString(c.name + "=" + c.value + "\n")
+		}
+		combined = sb.String()
+	default:
+		return nil, fmt.Errorf("unsupported fingerprint scheme: %d", scheme)
+	}
+
+	// SHA256 生成指纹，配置产品盐时为 HMAC-SHA256
+	return sum(combined), nil
+}
+
+// FormatActivationCode 生成带版本前缀的激活码，旧版本算法保持 "XXXX-XXXX-XXXX-XXXX"
+func FormatActivationCode(scheme int, b []byte) (string, error) {
+	code, err := ToActivationCodeFromBytes(b)
+	if err != nil || scheme == SchemeLegacy {
+		return code, err
+	}
+	return fmt.Sprintf("V%d-%s", scheme, code), nil
+}
+
+// SplitActivationCode 拆出激活码的算法版本，不带前缀的激活码为 SchemeLegacy
+func SplitActivationCode(code string) (int, string, error) {
+	s := NormalizeActivationCode(code)
+	// 旧激活码首段为 4 个字符，带版本前缀时首段为 "V<版本>"
+	if head, rest, ok := strings.Cut(s, "-"); ok && len(head) < 4 && strings.HasPrefix(head, "V") {
+		scheme, err := strconv.Atoi(head[1:])
+		if err != nil || !ValidScheme(scheme) {
+			return 0, "", fmt.Errorf("unsupported fingerprint scheme: %s", head)
+		}
+		return scheme, rest, nil
+	}
+	// 不带连字符时为 "V2" + 16 个字符（+ 校验位）
+	if (len(s) == 18 || len(s) == 19) && s[0] == 'V' && !strings.Contains(s, "-") {
+		scheme, err := strconv.Atoi(s[1:2])
+		if err != nil || !ValidScheme(scheme) {
+			return 0, "", fmt.Errorf("unsupported fingerprint scheme: %s", s[:2])
+		}
+		return scheme, s[2:], nil
+	}
+	return SchemeLegacy, s, nil
+}
+
+// DecodeActivationCodeToHex 将 "XXXX-XXXX-XXXX-XXXX-C"（可带 "V2-" 版本前缀，旧激活码不带校验位）-> hex string
+func DecodeActivationCodeToHex(code string) (string, error) {
+	_, h, err := DecodeActivationCode(code)
+	return h, err
+}
+
+// DecodeActivationCode 解析激活码，返回指纹算法版本和 hex 指纹，校验位不匹配时返回 *ChecksumError；
+// 接受不带校验位的旧激活码，用于校验已有的许可证
+func DecodeActivationCode(code string) (int, string, error) {
+	return decodeActivationCode(code, VerifyCheckChar)
+}
+
+// DecodeActivationCodeStrict 与 DecodeActivationCode 相同，但激活码必须带校验位，用于激活和签发
+func DecodeActivationCodeStrict(code string) (int, string, error) {
+	return decodeActivationCode(code, RequireCheckChar)
+}
+
+func decodeActivationCode(code string, check func(string) error) (int, string, error) {
+	scheme, rest, err := SplitActivationCode(code)
+	if err != nil {
+		return 0, "", err
+	}
+	s := strings.ReplaceAll(rest, "-", "")
+	if err := check(s); err != nil {
+		return 0, "", err
+	}
+	b, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s[:16])
+	if err != nil {
+		return 0, "", err
+	}
+	return scheme, hex.EncodeToString(b), nil
+}
+
+// ------------------ 多组件指纹 ------------------
+
+// 指纹组件名称
+const (
+	ComponentMachineID = "machine-id"
+	ComponentMAC       = "mac"
+	ComponentHostname  = "hostname"
+)
+
+// Components 各组件独立哈希后的指纹，键为组件名，值为 SHA256 hex，获取失败的组件不出现
+type Components map[string]string
+
+// GetComponents 获取本机各组件的哈希，许可证绑定这些哈希后可以容忍单个组件变化
+func GetComponents() Components {
+	comps := Components{}
+	for _, c := range Active() {
+		v, err := c.Collect()
+		if err != nil || v == "" {
+			continue
+		}
+		comps[c.Name()] = hashComponent(c.Name(), v)
+	}
+	return comps
+}
+
+// hashComponent 组件名参与哈希，避免不同组件的相同取值得到相同哈希
+func hashComponent(name, value string) string {
+	return hex.EncodeToString(sum(name + "|" + value))
+}
+
+// MatchPolicy 模糊匹配策略：匹配组件的权重之和达到 Threshold 即视为同一台机器
+type MatchPolicy struct {
+	Weights   map[string]int
+	Threshold int
+}
+
+// DefaultMatchPolicy 默认策略：machine-id 权重为 2，MAC 和主机名各为 1，需要达到 3，
+// 即 machine-id 加任意一个其他组件匹配，改主机名或换网卡都不影响许可证
+var DefaultMatchPolicy = MatchPolicy{
+	Weights: map[string]int{
+		ComponentMachineID:  2,
+		ComponentMAC:        1,
+		ComponentHostname:   1,
+		ComponentDMIUUID:    2,
+		ComponentTPMEK:      2,
+		ComponentDiskSerial: 1,
+		ComponentCPU:        1,
+		ComponentFile:       1,
+	},
+	Threshold: 3,
+}
+
+// MatchResult 匹配结果
+type MatchResult struct {
+	Score     int
+	Threshold int
+	Matched   []string
+	Missed    []string
+}
+
+// OK 是否达到匹配阈值
+func (r MatchResult) OK() bool {
+	return r.Score >= r.Threshold
+}
+
+// Match 比较许可证绑定的组件和本机组件
+func (p MatchPolicy) Match(bound, local Components) MatchResult {
+	res := MatchResult{Threshold: p.Threshold}
+	names := make([]string, 0, len(bound))
+	for name := range bound {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if v, ok := local[name]; ok && v == bound[name] {
+			res.Score += p.Weights[name]
+			res.Matched = append(res.Matched, name)
+		} else {
+			res.Missed = append(res.Missed, name)
+		}
+	}
+	return res
+}
+
+// ToActivationCodeFromHex 将指纹（hex 或 raw bytes）转成 16-char base32 加校验位的激活码 "XXXX-XXXX-XXXX-XXXX-C"
+func ToActivationCodeFromHex(hexStr string) (string, error) {
+	b, err := hex.DecodeString(strings.TrimSpace(hexStr))
+	if err != nil {
+		return "", err
+	}
+	return ToActivationCodeFromBytes(b)
+}
+
+// ToActivationCodeFromBytes 从原始字节数组生成激活码
+// 规则：取 bytes 的前 10 个字节（不足则用整个数组并右补 0），用 RFC4648 base32 大写编码 -> 得到 16 字符 -> 分段 4-4-4-4，
+// 最后追加一段 Luhn mod 32 校验位，电话抄错一个字符或相邻字符颠倒时可以发现
+func ToActivationCodeFromBytes(b []byte) (string, error) {
+	const targetBytes = 10 // 80 bits -> 16 base32 chars
+	buf := make([]byte, targetBytes)
+	if len(b) >= targetBytes {
+		copy(buf, b[:targetBytes])
+	} else {
+		copy(buf, b)
+		// 若不足 10 字节，后面已是 0 补齐（可接受）
+	}
+
+	// 使用标准 base32 (RFC4648) 大写，无 padding
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	s := enc.EncodeToString(buf) // 16 chars for 10 bytes
+	if len(s) != 16 {
+		return "", errors.New("unexpected encoded length")
+	}
+	// 格式化为 4-4-4-4-C
+	parts := []string{s[0:4], s[4:8], s[8:12], s[12:16], string(checkChar(s))}
+	return strings.Join(parts, "-"), nil
+}
@@ -0,0 +1,133 @@
+package hwid
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ------------------ MAC 选择 ------------------
+
+// net.Interfaces 的顺序会随 docker0、veth、VPN、USB 网卡的增删而变化，
+// 因此按固定策略选择：排除虚拟网卡，优先物理网卡和出厂（永久）地址，同级按名称排序
+
+// 按名称识别的虚拟网卡前缀，容器中没有 /sys/class/net/*/device 时也能排除
+var virtualIfacePrefixes = []string{
+	"docker", "veth", "br-", "virbr", "tun", "tap", "vboxnet", "vmnet",
+	"wg", "zt", "tailscale", "utun", "ppp", "ifb", "dummy", "bond", "team",
+}
+
+// MACCandidate 参与选择的网卡
+type MACCandidate struct {
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Physical  bool   `json:"physical"`           // 存在 /sys/class/net/<name>/device
+	Permanent bool   `json:"permanent"`          // addr_assign_type 为 0（出厂地址）
+	Excluded  string `json:"excluded,omitempty"` // 被排除的原因
+}
+
+// MACSelection MAC 选择结果，供诊断接口展示
+type MACSelection struct {
+	Interface  string         `json:"interface"`
+	Address    string         `json:"address"`
+	Candidates []MACCandidate `json:"candidates"`
+}
+
+// SelectMAC 选择组成指纹的网卡，root 为 /sys 所在的根目录
+func SelectMAC(root string) (*MACSelection, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	sel := &MACSelection{}
+	for _, iface := range ifaces {
+		sel.Candidates = append(sel.Candidates, classifyIface(root, iface))
+	}
+	sort.Slice(sel.Candidates, func(i, j int) bool {
+		return sel.Candidates[i].Name < sel.Candidates[j].Name
+	})
+
+	var best *MACCandidate
+	for i := range sel.Candidates {
+		c := &sel.Candidates[i]
+		if c.Excluded != "" {
+			continue
+		}
+		// 候选已按名称排序，只有优先级更高才替换
+		if best == nil || macRank(c) > macRank(best) {
+			best = c
+		}
+	}
+	if best != nil {
+		sel.Interface = best.Name
+		sel.Address = best.Address
+	}
+	return sel, nil
+}
+
+// FirstMAC 按 net.Interfaces 的顺序返回首个非回环、有硬件地址的网卡，SchemeLegacy 使用该规则
+func FirstMAC() (string, error) {
+	iface, err := firstInterface()
+	if err != nil {
+		return "", err
+	}
+	return iface.HardwareAddr.String(), nil
+}
+
+func firstInterface() (net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return net.Interface{}, err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) == 0 {
+			continue
+		}
+		return iface, nil
+	}
+	return net.Interface{}, errNoData
+}
+
+func classifyIface(root string, iface net.Interface) MACCandidate {
+	c := MACCandidate{Name: iface.Name, Address: iface.HardwareAddr.String()}
+	sysDir := filepath.Join(root, "sys/class/net", iface.Name)
+	if _, err := os.Stat(filepath.Join(sysDir, "device")); err == nil {
+		c.Physical = true
+	}
+	if t, err := readTrimmed(sysDir, "addr_assign_type"); err == nil && t == "0" {
+		c.Permanent = true
+	}
+
+	switch {
+	case iface.Flags&net.FlagLoopback != 0:
+		c.Excluded = "loopback"
+	case len(iface.HardwareAddr) == 0:
+		c.Excluded = "no hardware address"
+	case !c.Physical && hasVirtualPrefix(iface.Name):
+		c.Excluded = "virtual interface"
+	}
+	return c
+}
+
+func hasVirtualPrefix(name string) bool {
+	for _, p := range virtualIfacePrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// macRank 物理网卡优先于虚拟网卡，出厂地址优先于随机或手动设置的地址
+func macRank(c *MACCandidate) int {
+	rank := 0
+	if c.Physical {
+		rank += 2
+	}
+	if c.Permanent {
+		rank++
+	}
+	return rank
+}
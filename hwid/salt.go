@@ -0,0 +1,86 @@
+package hwid
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ------------------ 指纹加盐 ------------------
+
+// 指纹加盐方式，写入签名的 fp_salt 声明。未加盐时同一台机器在所有使用本库的产品中
+// 激活码相同，可以被用来跨厂商关联机器；加盐后各组件和指纹改为以产品盐为密钥的 HMAC-SHA256
+const (
+	SaltNone     = ""         // 不加盐（旧许可证）
+	SaltProduct  = "product"  // 按产品加盐
+	SaltCustomer = "customer" // 按产品和客户加盐，同一产品的不同客户之间也无法关联
+)
+
+// ValidSaltMode 判断加盐方式是否受支持
+func ValidSaltMode(mode string) bool {
+	switch mode {
+	case SaltNone, SaltProduct, SaltCustomer:
+		return true
+	}
+	return false
+}
+
+// DecodeSalt 解析 base64 编码的产品指纹盐，至少 16 字节
+func DecodeSalt(s string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(salt) < 16 {
+		return nil, errors.New("fingerprint salt must be base64 of at least 16 bytes")
+	}
+	return salt, nil
+}
+
+// deriveKey 按客户加盐时由产品盐派生客户密钥
+func deriveKey(salt []byte, customer string) []byte {
+	if customer == "" {
+		return salt
+	}
+	m := hmac.New(sha256.New, salt)
+	m.Write([]byte("customer|" + customer))
+	return m.Sum(nil)
+}
+
+// Salting 当前配置的加盐方式，以及按客户加盐时的客户名称
+func Salting() (mode, customer string) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return saltMode, saltCustomer
+}
+
+// CheckSalt 检查许可证的加盐方式与本机配置一致，不一致时本机指纹不可能匹配
+func CheckSalt(mode, customer string) error {
+	local, localCustomer := Salting()
+	return MatchSalt(mode, customer, local, localCustomer)
+}
+
+// MatchSalt 比较许可证的加盐方式与给定的本机加盐方式
+func MatchSalt(mode, customer, local, localCustomer string) error {
+	if mode != local {
+		return fmt.Errorf("fingerprint salt mismatch: license uses %q, configured %q", mode, local)
+	}
+	if mode == SaltCustomer && customer != localCustomer {
+		return errors.New("fingerprint salt mismatch: license was issued for another customer")
+	}
+	return nil
+}
+
+// sum 计算指纹摘要：未配置盐时为 SHA256，否则为 HMAC-SHA256
+func sum(data string) []byte {
+	mu.RLock()
+	key := saltKey
+	mu.RUnlock()
+	if key == nil {
+		h := sha256.Sum256([]byte(data))
+		return h[:]
+	}
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
@@ -0,0 +1,252 @@
+package hwid
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// ------------------ 虚拟机检测 ------------------
+
+// DMI、WMI、ioreg 中的厂商/型号字符串与虚拟化平台的对应关系
+var hypervisorSignatures = []struct {
+	match, name string
+}{
+	{"vmware", "vmware"},
+	{"virtualbox", "virtualbox"},
+	{"innotek", "virtualbox"},
+	{"qemu", "kvm"},
+	{"kvm", "kvm"},
+	{"bochs", "kvm"},
+	{"xen", "xen"},
+	{"parallels", "parallels"},
+	{"bhyve", "bhyve"},
+	{"virtual machine", "hyperv"}, // Microsoft Corporation / Virtual Machine
+	{"amazon ec2", "aws"},
+	{"google compute engine", "gce"},
+	{"openstack", "openstack"},
+	{"virtualmac", "apple"}, // macOS Virtualization.framework
+	{"vrtual", "hyperv"},    // Hyper-V 的 BIOS 版本 "VRTUAL - 1"
+	{"vbox", "virtualbox"},
+}
+
+// VMInfo 虚拟机检测结果
+type VMInfo struct {
+	Virtual    bool     `json:"virtual"`
+	Hypervisor string   `json:"hypervisor,omitempty"`
+	Evidence   []string `json:"evidence,omitempty"`
+	// Unavailable 无法检测的原因，例如不支持的操作系统或检测命令执行失败，此时 Virtual 不可信
+	Unavailable string `json:"unavailable,omitempty"`
+}
+
+// DetectVM 检测本机是否为虚拟机：Linux 读取 DMI 字符串、CPUID 的 hypervisor 位（/proc/cpuinfo flags）
+// 和 /sys/hypervisor，root 为 / 所在的根目录；macOS 读取 kern.hv_vmm_present 和 ioreg 中的型号、厂商；
+// Windows 读取 WMI 中的计算机和 BIOS 厂商、型号
+func DetectVM(root string) VMInfo {
+	info := VMInfo{}
+	found := func(hypervisor, evidence string) {
+		info.Virtual = true
+		if info.Hypervisor == "" && hypervisor != "" {
+			info.Hypervisor = hypervisor
+		}
+		info.Evidence = append(info.Evidence, evidence)
+	}
+
+	var err error
+	switch runtime.GOOS {
+	case "linux":
+		err = detectVMLinux(root, found)
+	case "darwin":
+		err = detectVMDarwin(found)
+	case "windows":
+		err = detectVMWindows(found)
+	default:
+		err = fmt.Errorf("unsupported os %s", runtime.GOOS)
+	}
+	if err != nil && !info.Virtual {
+		info.Unavailable = err.Error()
+	}
+	return info
+}
+
+// matchHypervisor 按厂商/型号字符串匹配虚拟化平台
+func matchHypervisor(v string) (string, bool) {
+	lv := strings.ToLower(v)
+	for _, sig := range hypervisorSignatures {
+		if strings.Contains(lv, sig.match) {
+			return sig.name, true
+		}
+	}
+	return "", false
+}
+
+// detectVMLinux DMI、/proc/cpuinfo 和 /sys/hypervisor 都无法读取时返回错误
+func detectVMLinux(root string, found func(hypervisor, evidence string)) error {
+	readable := false
+	for _, f := range []string{"sys_vendor", "product_name", "board_vendor", "bios_vendor"} {
+		v, err := readTrimmed(root, filepath.Join("sys/class/dmi/id", f))
+		if err != nil {
+			continue
+		}
+		readable = true
+		if name, ok := matchHypervisor(v); ok {
+			found(name, "dmi "+f+": "+v)
+		}
+	}
+
+	if b, err := os.ReadFile(filepath.Join(root, "proc/cpuinfo")); err == nil {
+		readable = true
+		for _, line := range strings.Split(string(b), "\n") {
+			k, v, ok := strings.Cut(line, ":")
+			if !ok || strings.TrimSpace(k) != "flags" {
+				continue
+			}
+			for _, flag := range strings.Fields(v) {
+				if flag == "hypervisor" {
+					found("", "cpuid hypervisor bit")
+					break
+				}
+			}
+			break
+		}
+	}
+
+	if t, err := readTrimmed(root, "sys/hypervisor/type"); err == nil {
+		readable = true
+		found(t, "/sys/hypervisor/type: "+t)
+	}
+	if !readable {
+		return errors.New("cannot read dmi, /proc/cpuinfo or /sys/hypervisor")
+	}
+	return nil
+}
+
+// detectVMDarwin kern.hv_vmm_present 在虚拟机中为 1（CPUID 的 hypervisor 位），
+// ioreg 的 model、manufacturer 在 VMware、Parallels 等平台中为虚拟化厂商
+func detectVMDarwin(found func(hypervisor, evidence string)) error {
+	var errs []string
+	if out, err := exec.Command("sysctl", "-n", "kern.hv_vmm_present").Output(); err != nil {
+		errs = append(errs, fmt.Sprintf("sysctl: %v", err))
+	} else if strings.TrimSpace(string(out)) == "1" {
+		found("", "kern.hv_vmm_present: 1")
+	}
+
+	out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("ioreg: %v", err))
+	} else {
+		for _, line := range strings.Split(string(out), "\n") {
+			// "model" = <"VMware7,1">
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			k = strings.Trim(strings.TrimSpace(k), `"`)
+			if k != "model" && k != "manufacturer" {
+				continue
+			}
+			v = strings.Trim(strings.TrimSpace(v), `<>"`)
+			if name, ok := matchHypervisor(v); ok {
+				found(name, "ioreg "+k+": "+v)
+			}
+		}
+	}
+	// 两种方式都失败时无法判断
+	if len(errs) == 2 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// detectVMWindows 不使用 Win32_ComputerSystem.HypervisorPresent：启用 Hyper-V 或基于虚拟化的安全时
+// 物理机的 CPUID 同样带有 hypervisor 位
+func detectVMWindows(found func(hypervisor, evidence string)) error {
+	var errs []string
+	for _, q := range [][]string{
+		{"computersystem", "get", "Manufacturer,Model", "/value"},
+		{"bios", "get", "Manufacturer,SMBIOSBIOSVersion", "/value"},
+	} {
+		out, err := exec.Command("wmic", q...).Output()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("wmic %s: %v", q[0], err))
+			continue
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			// Manufacturer=VMware, Inc.
+			k, v, ok := strings.Cut(strings.TrimSpace(line), "=")
+			if !ok || v == "" {
+				continue
+			}
+			if name, ok := matchHypervisor(v); ok {
+				found(name, "wmi "+q[0]+" "+k+": "+v)
+			}
+		}
+	}
+	if len(errs) == 2 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ------------------ 虚拟机策略 ------------------
+
+// 许可证的虚拟机策略，写入签名的 vm_policy 声明
+const (
+	VMPolicyAny           = ""                 // 不限制（旧许可证）
+	VMPolicyPhysicalOnly  = "physical-only"    // 只允许物理机
+	VMPolicyAllowed       = "vm-allowed"       // 允许虚拟机
+	VMPolicyInstanceBound = "vm-instance-uuid" // 允许虚拟机，但绑定虚拟机实例 UUID，克隆出的虚拟机 UUID 不同
+)
+
+// ValidVMPolicy 判断虚拟机策略是否受支持
+func ValidVMPolicy(policy string) bool {
+	switch policy {
+	case VMPolicyAny, VMPolicyPhysicalOnly, VMPolicyAllowed, VMPolicyInstanceBound:
+		return true
+	}
+	return false
+}
+
+// InstanceUUIDHash 本机 DMI 产品 UUID 的哈希，与 dmi-uuid 组件哈希相同，读取失败时返回空
+func InstanceUUIDHash() string {
+	v, err := DMIUUIDCollector{Root: "/"}.Collect()
+	if err != nil {
+		return ""
+	}
+	return hashComponent(ComponentDMIUUID, v)
+}
+
+// CheckVMPolicy 按许可证的虚拟机策略检查本机，instanceHash 为许可证绑定的实例 UUID 哈希
+func CheckVMPolicy(policy, instanceHash string) error {
+	switch policy {
+	case VMPolicyAny, VMPolicyAllowed:
+		return nil
+	case VMPolicyPhysicalOnly:
+		vm := DetectVM("/")
+		if vm.Virtual {
+			return fmt.Errorf("license does not allow virtual machines (%s)", strings.Join(vm.Evidence, "; "))
+		}
+		// 无法检测时不能确认是物理机
+		if vm.Unavailable != "" {
+			return fmt.Errorf("cannot verify physical machine: %s", vm.Unavailable)
+		}
+		return nil
+	case VMPolicyInstanceBound:
+		if !DetectVM("/").Virtual {
+			return nil
+		}
+		local := InstanceUUIDHash()
+		if local == "" {
+			return errors.New("cannot read virtual machine instance uuid")
+		}
+		if local != instanceHash {
+			return errors.New("virtual machine instance uuid mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported vm policy: %s", policy)
+}
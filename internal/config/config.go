@@ -6,9 +6,76 @@ import (
 )
 
 type Config struct {
-	PrivateKeyPath   string `json:"privateKeyPath"`
-	PublicKeyPath    string `json:"publicKeyPath"`
-	LicenseStorePath string `json:"licenseStorePath"`
+	PrivateKeyPath   string          `json:"privateKeyPath"`
+	PublicKeyPath    string          `json:"publicKeyPath"`
+	LicenseStorePath string          `json:"licenseStorePath"`
+	SignerType       string          `json:"signerType"` // 签名器类型：file（默认）或 pkcs11
+	PKCS11           PKCS11Config    `json:"pkcs11"`
+	Product          string          `json:"product"`  // 本服务自身作为哪个产品被授权
+	Products         []ProductConfig `json:"products"` // 可签发的产品列表
+	Hwid             HwidConfig      `json:"hwid"`
+	// LicenseAllow 不检查服务自身许可证的请求，为空时放行激活接口和指纹查询接口
+	LicenseAllow []AllowRule `json:"licenseAllow"`
+	// LicenseRecheckSeconds 服务自身许可证完整重新校验的间隔（秒），为 0 时默认 10 分钟，
+	// 许可证文件或数据库变化时立即重新校验
+	LicenseRecheckSeconds int `json:"licenseRecheckSeconds"`
+	// LicenseSources 服务自身许可证的来源，按优先级排列，为空时依次读取数据库和 licenseStorePath
+	LicenseSources []SourceConfig `json:"licenseSources"`
+	// LicenseClockState 时钟回拨检测的时间记录文件，为空时不检测
+	LicenseClockState string `json:"licenseClockState"`
+	// LicenseClockSecret 派生时间记录 HMAC 密钥的密钥（base64），建议留空改用环境变量 LICENSE_CLOCK_SECRET；
+	// 都为空时使用本产品的加密密钥或短许可证密钥
+	LicenseClockSecret string `json:"licenseClockSecret"`
+	// LicenseStatusPath 非空时在该路径返回服务自身许可证的状态（JSON）
+	LicenseStatusPath string `json:"licenseStatusPath"`
+}
+
+// SourceConfig 许可证来源：database（本机的激活记录）、file、dir、env、remote
+type SourceConfig struct {
+	Type string `json:"type"`
+	Path string `json:"path"` // file 的文件路径或 dir 的目录
+	Name string `json:"name"` // env 的环境变量名
+	URL  string `json:"url"`  // remote 的下载地址
+}
+
+// AllowRule 许可证中间件的放行规则，Path 按 path.Match 匹配，以 /** 结尾时匹配前缀，Methods 为空表示所有方法
+type AllowRule struct {
+	Methods []string `json:"methods"`
+	Path    string   `json:"path"`
+}
+
+// HwidConfig 机器指纹采集配置
+type HwidConfig struct {
+	Collectors []string `json:"collectors"` // 组成指纹的采集器，为空时使用 machine-id、mac、hostname
+	File       string   `json:"file"`       // file 采集器读取的文件路径
+	// Binding 本服务自身许可证的绑定方式：machine（默认）、host-machine-id、k8s-cluster、volume
+	Binding       string `json:"binding"`
+	HostMachineID string `json:"hostMachineId"` // 挂载进容器的宿主机 machine-id 路径
+	VolumeID      string `json:"volumeId"`      // 持久卷中的实例标识文件路径
+	// Scheme 生成激活码的指纹算法版本：1（默认，兼容旧激活码）或 2
+	Scheme int `json:"scheme"`
+	// Salt base64 编码的产品指纹盐（至少 16 字节），配置后指纹无法与其他产品关联
+	Salt     string `json:"salt"`
+	Customer string `json:"customer"` // 按客户加盐时的客户名称
+}
+
+// ProductConfig 产品配置
+type ProductConfig struct {
+	Name           string `json:"name"`
+	ID             uint16 `json:"id"`             // 产品编号，写入短许可证密钥
+	Encrypt        bool   `json:"encrypt"`        // 是否签发加密许可证（JWE）
+	EncryptionKey  string `json:"encryptionKey"`  // base64 编码的 32 字节 A256GCM 密钥
+	ShortKeySecret string `json:"shortKeySecret"` // base64 编码的短许可证密钥 HMAC 密钥
+	// FingerprintSalt 产品客户端的指纹加盐方式：product、customer，为空表示不加盐
+	FingerprintSalt string `json:"fingerprintSalt"`
+}
+
+// PKCS11Config 签名私钥保存在 HSM/令牌中时的配置
+type PKCS11Config struct {
+	Module     string `json:"module"`     // PKCS#11 模块路径
+	TokenLabel string `json:"tokenLabel"` // 令牌标签
+	Pin        string `json:"pin"`        // 用户 PIN，建议留空改用环境变量 LICENSE_PKCS11_PIN
+	KeyLabel   string `json:"keyLabel"`   // 私钥标签
 }
 
 var Conf *Config
@@ -16,6 +16,7 @@ type LicenseActivation struct {
 	Fingerprint string    `json:"fingerprint"`
 	License     string    `json:"license"`
 	Description string    `json:"description"`
+	Product     string    `json:"product"`
 	IssuedAt    time.Time `json:"issued_at"`
 	ExpiresAt   time.Time `json:"expires_at"`
 	ActivatedAt time.Time `json:"activated_at"`
@@ -82,6 +83,7 @@ func (db *DB) createTables() error {
 		fingerprint TEXT NOT NULL,
 		license TEXT NOT NULL,
 		description TEXT DEFAULT '',
+		product TEXT NOT NULL DEFAULT '',
 		issued_at INTEGER NOT NULL,
 		expires_at INTEGER NOT NULL,
 		activated_at INTEGER NOT NULL,
@@ -130,6 +132,7 @@ func (db *DB) runMigrations() error {
 	// 检查是否需要添加description字段
 	var hasDescriptionColumn bool
 	var hasDeleteColumn bool
+	var hasProductColumn bool
 	checkColumnQuery := `PRAGMA table_info(license_activations);`
 	rows, err := db.conn.Query(checkColumnQuery)
 	if err != nil {
@@ -154,6 +157,9 @@ func (db *DB) runMigrations() error {
 		if name == "is_delete" {
 			hasDeleteColumn = true
 		}
+		if name == "product" {
+			hasProductColumn = true
+		}
 	}
 
 	// 如果没有description字段，则添加
@@ -192,6 +198,24 @@ func (db *DB) runMigrations() error {
 		log.Println("Database migration completed: Added is_delete column to license_activations table")
 	}
 
+	// 如果没有product字段，则添加
+	if !hasProductColumn {
+		migrationQuery := `ALTER TABLE license_activations ADD COLUMN product TEXT NOT NULL DEFAULT '';`
+		_, err := db.conn.Exec(migrationQuery)
+		if err != nil {
+			return fmt.Errorf("failed to add product column: %v", err)
+		}
+
+		// 记录迁移
+		insertMigrationQuery := `INSERT INTO schema_migrations (version) VALUES ('add_product_column');`
+		_, err = db.conn.Exec(insertMigrationQuery)
+		if err != nil {
+			return fmt.Errorf("failed to record migration: %v", err)
+		}
+
+		log.Println("Database migration completed: Added product column to license_activations table")
+	}
+
 	return nil
 }
 
@@ -199,8 +223,8 @@ func (db *DB) runMigrations() error {
 func (db *DB) InsertLicenseActivation(activation *LicenseActivation) error {
 	query := `
 	INSERT INTO license_activations 
-	(customer, fingerprint, license, description, issued_at, expires_at, activated_at, is_active)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	(customer, fingerprint, license, description, product, issued_at, expires_at, activated_at, is_active)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	_, err := db.conn.Exec(
@@ -209,6 +233,7 @@ func (db *DB) InsertLicenseActivation(activation *LicenseActivation) error {
 		activation.Fingerprint,
 		activation.License,
 		activation.Description,
+		activation.Product,
 		activation.IssuedAt.Unix(),
 		activation.ExpiresAt.Unix(),
 		activation.ActivatedAt.Unix(),
@@ -225,7 +250,7 @@ func (db *DB) InsertLicenseActivation(activation *LicenseActivation) error {
 // GetLicenseActivationByFingerprint 根据指纹获取许可证激活记录
 func (db *DB) GetLicenseActivationByFingerprint(fingerprint string) (*LicenseActivation, error) {
 	query := `
-	SELECT id, customer, fingerprint, license, description, issued_at, expires_at, activated_at, is_active, is_delete
+	SELECT id, customer, fingerprint, license, description, product, issued_at, expires_at, activated_at, is_active, is_delete
 	FROM license_activations
 	WHERE fingerprint = ? AND is_active = 1 AND is_delete = 0
 	ORDER BY activated_at DESC
@@ -241,6 +266,7 @@ func (db *DB) GetLicenseActivationByFingerprint(fingerprint string) (*LicenseAct
 		&activation.Fingerprint,
 		&activation.License,
 		&activation.Description,
+		&activation.Product,
 		&issuedAt,
 		&expiresAt,
 		&activatedAt,
@@ -265,7 +291,7 @@ func (db *DB) GetLicenseActivationByFingerprint(fingerprint string) (*LicenseAct
 // GetActiveLicenseActivationByFingerprint 根据指纹获取有效的许可证激活记录
 func (db *DB) GetActiveLicenseActivationByFingerprint(fingerprint string) (*LicenseActivation, error) {
 	query := `
-	SELECT id, customer, fingerprint, license, description, issued_at, expires_at, activated_at, is_active, is_delete
+	SELECT id, customer, fingerprint, license, description, product, issued_at, expires_at, activated_at, is_active, is_delete
 	FROM license_activations
 	WHERE fingerprint = ? AND is_active = 1 AND expires_at > ? AND is_delete = 0
 	ORDER BY activated_at DESC
@@ -281,6 +307,7 @@ func (db *DB) GetActiveLicenseActivationByFingerprint(fingerprint string) (*Lice
 		&activation.Fingerprint,
 		&activation.License,
 		&activation.Description,
+		&activation.Product,
 		&issuedAt,
 		&expiresAt,
 		&activatedAt,
@@ -305,7 +332,7 @@ func (db *DB) GetActiveLicenseActivationByFingerprint(fingerprint string) (*Lice
 // GetLicenseActivationByID 根据ID获取许可证激活记录
 func (db *DB) GetLicenseActivationByID(id int64) (*LicenseActivation, error) {
 	query := `
-	SELECT id, customer, fingerprint, license, description, issued_at, expires_at, activated_at, is_active, is_delete
+	SELECT id, customer, fingerprint, license, description, product, issued_at, expires_at, activated_at, is_active, is_delete
 	FROM license_activations
 	WHERE id = ? AND is_delete = 0
 	`
@@ -319,6 +346,7 @@ func (db *DB) GetLicenseActivationByID(id int64) (*LicenseActivation, error) {
 		&activation.Fingerprint,
 		&activation.License,
 		&activation.Description,
+		&activation.Product,
 		&issuedAt,
 		&expiresAt,
 		&activatedAt,
@@ -382,7 +410,7 @@ func (db *DB) GetLicenseActivationsWithPaginationAndSearch(page, pageSize int, c
 
 	// 分页查询
 	query := `
-	SELECT id, customer, fingerprint, license, description, issued_at, expires_at, activated_at, is_active, is_delete
+	SELECT id, customer, fingerprint, license, description, product, issued_at, expires_at, activated_at, is_active, is_delete
 	FROM license_activations
 	` + whereClause + `
 	ORDER BY activated_at DESC
@@ -408,6 +436,7 @@ func (db *DB) GetLicenseActivationsWithPaginationAndSearch(page, pageSize int, c
 			&activation.Fingerprint,
 			&activation.License,
 			&activation.Description,
+			&activation.Product,
 			&issuedAt,
 			&expiresAt,
 			&activatedAt,
@@ -436,7 +465,7 @@ func (db *DB) GetLicenseActivationsWithPaginationAndSearch(page, pageSize int, c
 // GetAllLicenseActivations 获取所有许可证激活记录（兼容旧版）
 func (db *DB) GetAllLicenseActivations() ([]LicenseActivation, error) {
 	query := `
-	SELECT id, customer, fingerprint, license, description, issued_at, expires_at, activated_at, is_active
+	SELECT id, customer, fingerprint, license, description, product, issued_at, expires_at, activated_at, is_active
 	FROM license_activations
 	ORDER BY activated_at DESC
 	`
@@ -459,6 +488,7 @@ func (db *DB) GetAllLicenseActivations() ([]LicenseActivation, error) {
 			&activation.Fingerprint,
 			&activation.License,
 			&activation.Description,
+			&activation.Product,
 			&issuedAt,
 			&expiresAt,
 			&activatedAt,
@@ -483,6 +513,19 @@ func (db *DB) GetAllLicenseActivations() ([]LicenseActivation, error) {
 	return activations, nil
 }
 
+// ActiveLicenseActivationsVersion 有效激活记录的版本标识，新增、停用或删除激活记录时变化，
+// 用于廉价判断是否需要重新读取激活记录
+func (db *DB) ActiveLicenseActivationsVersion() (string, error) {
+	query := `SELECT COUNT(*), COALESCE(MAX(id), 0), COALESCE(SUM(id), 0) FROM license_activations WHERE is_active = 1 AND is_delete = 0`
+
+	var count, maxID, sumID int64
+	if err := db.conn.QueryRow(query).Scan(&count, &maxID, &sumID); err != nil {
+		return "", fmt.Errorf("failed to get license activations version: %v", err)
+	}
+
+	return fmt.Sprintf("%d:%d:%d", count, maxID, sumID), nil
+}
+
 // DeactivateLicense 将许可证标记为非活动状态
 func (db *DB) DeactivateLicense(id int) error {
 	query := `UPDATE license_activations SET is_active = 0 WHERE id = ?`
@@ -498,7 +541,7 @@ func (db *DB) DeactivateLicense(id int) error {
 // GetExpiredLicenses 获取已过期的许可证
 func (db *DB) GetExpiredLicenses() ([]LicenseActivation, error) {
 	query := `
-	SELECT id, customer, fingerprint, license, description, issued_at, expires_at, activated_at, is_active, is_delete
+	SELECT id, customer, fingerprint, license, description, product, issued_at, expires_at, activated_at, is_active, is_delete
 	FROM license_activations
 	WHERE expires_at < ? AND is_active = 1 AND is_delete = 0
 	ORDER BY expires_at ASC
@@ -522,6 +565,7 @@ func (db *DB) GetExpiredLicenses() ([]LicenseActivation, error) {
 			&activation.Fingerprint,
 			&activation.License,
 			&activation.Description,
+			&activation.Product,
 			&issuedAt,
 			&expiresAt,
 			&activatedAt,
@@ -0,0 +1,66 @@
+package license
+
+import (
+	"net/http"
+	"strconv"
+
+	"license/hwid"
+	"license/verify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ------------------ Fingerprint Details Handler ------------------
+
+// FingerprintDetailsHandler 说明本机指纹的组成（各组件来源、哈希和最终激活码），
+// POST 许可证文件时进一步说明该许可证与本机哪些组件不一致，用于排查 fingerprint mismatch
+func FingerprintDetailsHandler(issuer *Issuer, products Products) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		binding := c.DefaultQuery("binding", hwid.DefaultBinding())
+		if !hwid.ValidBinding(binding) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported binding: " + binding})
+			return
+		}
+		scheme := hwid.DefaultScheme()
+		if v := c.Query("scheme"); v != "" {
+			var err error
+			if scheme, err = strconv.Atoi(v); err != nil || !hwid.ValidScheme(scheme) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scheme"})
+				return
+			}
+		}
+
+		var req struct {
+			License string `json:"license"` // 许可证文件内容，按许可证的绑定方式和算法版本比较
+		}
+		if c.Request.Method == http.MethodPost {
+			if err := c.BindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+		}
+		if req.License == "" {
+			c.JSON(http.StatusOK, gin.H{"local": hwid.Explain(binding, scheme)})
+			return
+		}
+
+		lf, err := verify.DecodeLicenseFile(req.License)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid license: " + err.Error()})
+			return
+		}
+		product, err := products.Lookup(lf.Product)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 只验证签名，已过期的许可证也可以比较
+		cl, err := verify.Decode(issuer.PublicKey(), lf.Token, product.EncryptionKey)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid license: " + err.Error()})
+			return
+		}
+		cmp := verify.Compare(cl)
+		c.JSON(http.StatusOK, gin.H{"local": cmp.Local, "license": cmp})
+	}
+}
@@ -0,0 +1,256 @@
+package license
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/square/go-jose/v3"
+
+	"license/verify"
+)
+
+// ------------------ Issuer ------------------
+
+// IssuerConfig 签发器配置
+type IssuerConfig struct {
+	SignerType     string
+	PrivateKeyPath string
+	PublicKeyPath  string
+	PKCS11         PKCS11Config
+}
+
+// Issuer 长期存活的许可证签发器
+// 启动时加载签名器和公钥并校验两者配对，之后每次签发直接复用已构造的 jose.Signer
+type Issuer struct {
+	cfg IssuerConfig
+
+	mu         sync.RWMutex
+	signer     Signer
+	joseSigner jose.Signer
+	timeSigner jose.Signer
+	pub        *rsa.PublicKey
+	modTimes   map[string]time.Time
+}
+
+// issuerState 一次完整加载的结果，加载成功后整体替换
+type issuerState struct {
+	signer     Signer
+	joseSigner jose.Signer
+	timeSigner jose.Signer // 带 typ 头，签出的时间戳不能当作许可证使用
+	pub        *rsa.PublicKey
+	modTimes   map[string]time.Time
+}
+
+// NewIssuer 创建签发器，私钥和公钥不配对时直接返回错误
+func NewIssuer(cfg IssuerConfig) (*Issuer, error) {
+	st, err := loadIssuerState(cfg)
+	if err != nil {
+		return nil, err
+	}
+	i := &Issuer{cfg: cfg}
+	i.apply(st)
+	return i, nil
+}
+
+func loadIssuerState(cfg IssuerConfig) (*issuerState, error) {
+	modTimes := make(map[string]time.Time)
+	for _, path := range watchedPaths(cfg) {
+		if fi, err := os.Stat(path); err == nil {
+			modTimes[path] = fi.ModTime()
+		}
+	}
+
+	pub, err := verify.LoadPublicKey(cfg.PublicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load public key: %v", err)
+	}
+	signer, err := NewSigner(cfg.SignerType, cfg.PrivateKeyPath, cfg.PKCS11)
+	if err != nil {
+		return nil, err
+	}
+	joseSigner, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: signer}, nil)
+	if err != nil {
+		signer.Close()
+		return nil, fmt.Errorf("failed to create signer: %v", err)
+	}
+	timeSigner, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: signer}, (&jose.SignerOptions{}).WithType(verify.TimestampType))
+	if err != nil {
+		signer.Close()
+		return nil, fmt.Errorf("failed to create timestamp signer: %v", err)
+	}
+	if err := checkKeyPair(joseSigner, signer, pub); err != nil {
+		signer.Close()
+		return nil, err
+	}
+	return &issuerState{signer: signer, joseSigner: joseSigner, timeSigner: timeSigner, pub: pub, modTimes: modTimes}, nil
+}
+
+// checkKeyPair 确认私钥与公钥配对：先比较公钥，再做一次试签名并用公钥验证
+func checkKeyPair(joseSigner jose.Signer, signer Signer, pub *rsa.PublicKey) error {
+	signerPub, ok := signer.Public().Key.(*rsa.PublicKey)
+	if !ok || !signerPub.Equal(pub) {
+		return errors.New("private key does not match public key")
+	}
+	probe := []byte("license-key-pair-check")
+	jws, err := joseSigner.Sign(probe)
+	if err != nil {
+		return fmt.Errorf("failed to sign probe payload: %v", err)
+	}
+	if _, err := jws.Verify(pub); err != nil {
+		return fmt.Errorf("probe signature does not verify with public key: %v", err)
+	}
+	return nil
+}
+
+// watchedPaths 需要监控变更的密钥文件，PKCS#11 私钥不在文件系统中
+func watchedPaths(cfg IssuerConfig) []string {
+	paths := []string{cfg.PublicKeyPath}
+	if cfg.SignerType == "" || cfg.SignerType == SignerTypeFile {
+		paths = append(paths, cfg.PrivateKeyPath)
+	}
+	return paths
+}
+
+func (i *Issuer) apply(st *issuerState) {
+	i.mu.Lock()
+	old := i.signer
+	i.signer = st.signer
+	i.joseSigner = st.joseSigner
+	i.timeSigner = st.timeSigner
+	i.pub = st.pub
+	i.modTimes = st.modTimes
+	i.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+}
+
+// Reload 重新加载密钥，失败时保留当前密钥继续签发
+func (i *Issuer) Reload() error {
+	st, err := loadIssuerState(i.cfg)
+	if err != nil {
+		return err
+	}
+	i.apply(st)
+	return nil
+}
+
+// changed 判断密钥文件是否在上次加载后发生变化
+func (i *Issuer) changed() bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, path := range watchedPaths(i.cfg) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !fi.ModTime().Equal(i.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchReload 启动一个协程，收到 SIGHUP 或密钥文件变更时重新加载
+func (i *Issuer) WatchReload(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-hup:
+				log.Println("Received SIGHUP, reloading signing keys")
+			case <-ticker.C:
+				if !i.changed() {
+					continue
+				}
+				log.Println("Signing key files changed, reloading")
+			}
+			if err := i.Reload(); err != nil {
+				log.Printf("Failed to reload signing keys, keeping current keys: %v", err)
+			}
+		}
+	}()
+}
+
+// PublicKey 返回当前签发密钥对应的公钥
+func (i *Issuer) PublicKey() *rsa.PublicKey {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.pub
+}
+
+// Issue 签发许可证，返回紧凑序列化的 JWS；产品要求加密时返回包裹 JWS 的 JWE
+func (i *Issuer) Issue(c *verify.Claims, product Product) (string, error) {
+	c.Product = product.Name
+	// 绑定组件哈希时只按组件模糊匹配，写入指纹会让任一组件变化都因指纹不一致而失败
+	if c.ComponentBound() {
+		c.Fingerprint, c.FingerprintFull = "", ""
+	}
+	if c.ID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return "", fmt.Errorf("failed to generate license id: %v", err)
+		}
+		c.ID = hex.EncodeToString(id)
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal claims: %v", err)
+	}
+
+	i.mu.RLock()
+	jws, err := i.joseSigner.Sign(payload)
+	i.mu.RUnlock()
+	if err != nil {
+		return "", fmt.Errorf("failed to sign claims: %v", err)
+	}
+	compact, err := jws.CompactSerialize()
+	if err != nil {
+		return "", err
+	}
+	if product.Encrypt {
+		return EncryptLicense(compact, product.EncryptionKey)
+	}
+	return compact, nil
+}
+
+// SignTimestamp 签名当前时间，客户端用它代替本机时间判断许可证有效期
+func (i *Issuer) SignTimestamp(now time.Time, nonce string) (string, error) {
+	payload, err := json.Marshal(verify.Timestamp{Sub: verify.TimestampSubject, Time: now.Unix(), Nonce: nonce})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal timestamp: %v", err)
+	}
+	i.mu.RLock()
+	jws, err := i.timeSigner.Sign(payload)
+	i.mu.RUnlock()
+	if err != nil {
+		return "", fmt.Errorf("failed to sign timestamp: %v", err)
+	}
+	return jws.CompactSerialize()
+}
+
+// Close 释放签名器
+func (i *Issuer) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.signer == nil {
+		return nil
+	}
+	err := i.signer.Close()
+	i.signer = nil
+	return err
+}
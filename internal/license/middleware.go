@@ -1,180 +1,80 @@
 package license
 
 import (
+	"context"
 	"crypto/rsa"
-	"crypto/x509"
-	"encoding/base32"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
+	"license/hwid"
 	"license/internal/database"
-	"license/internal/hwid"
+	"license/middleware"
+	"license/middleware/ginmw"
+	"license/verify"
 
 	"github.com/gin-gonic/gin"
-	"github.com/square/go-jose/v3"
 )
 
-// ------------------ 公钥加载 ------------------
-
-func loadPublicKey(path string) (*rsa.PublicKey, error) {
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	block, _ := pem.Decode(b)
-	if block == nil {
-		return nil, errors.New("invalid pem")
-	}
-	pubIface, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
-		// try rsa pub1
-		rpub, err2 := x509.ParsePKCS1PublicKey(block.Bytes)
-		if err2 == nil {
-			return rpub, nil
-		}
-		return nil, err
-	}
-	if pub, ok := pubIface.(*rsa.PublicKey); ok {
-		return pub, nil
-	}
-	return nil, errors.New("not rsa pub")
-}
-
-// ------------------ License Claims ------------------
-
-type claims struct {
-	Iss         string `json:"iss"`
-	Sub         string `json:"sub"`
-	Customer    string `json:"customer"`
-	Fingerprint string `json:"fingerprint"`
-	Iat         int64  `json:"iat"`
-	Exp         int64  `json:"exp"`
-	// Meta omitted
-}
-
-// ------------------ JWS 验证 ------------------
-
-func verifyJWS(pub *rsa.PublicKey, jwsCompact string) (*claims, error) {
-	signed, err := jose.ParseSigned(jwsCompact)
-	if err != nil {
-		return nil, err
-	}
-	// verify signature
-	out, err := signed.Verify(pub)
-	if err != nil {
-		return nil, err
-	}
-	var c claims
-	if err := json.Unmarshal(out, &c); err != nil {
-		return nil, err
-	}
-	if time.Now().UTC().Unix() > c.Exp {
-		return nil, errors.New("expired")
-	}
-	return &c, nil
-}
-
 // ------------------ HWID helper ------------------
 
-// DecodeActivationCodeToHex 将 "XXXX-XXXX-XXXX-XXXX" -> hex string
-func DecodeActivationCodeToHex(code string) (string, error) {
-	s := strings.ToUpper(strings.ReplaceAll(strings.ReplaceAll(code, "-", ""), " ", ""))
-	if len(s) != 16 {
-		return "", fmt.Errorf("activation code must be 16 base32 chars")
+// decodeFullFingerprint 解析 64 位 hex 的完整指纹
+func decodeFullFingerprint(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(b) != sha256.Size {
+		return nil, errors.New("full fingerprint must be 64 hex chars")
 	}
-	b, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
-	if err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(b), nil
+	return b, nil
 }
 
-// ------------------ License Generation ------------------
-
-func generateLicense(pubKeyPath, privateKeyPath, customer, fingerprint string, issuedAt time.Time, exp int64) (string, error) {
-	// Load private key (assuming it's in the same directory as public key with .key extension)
-	b, err := os.ReadFile(privateKeyPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to read private key: %v", err)
-	}
-	block, _ := pem.Decode(b)
-	if block == nil {
-		return "", errors.New("invalid private key pem")
+// validateVMPolicy 校验签发请求中的虚拟机策略
+func validateVMPolicy(policy, instanceUUID string) error {
+	if !hwid.ValidVMPolicy(policy) {
+		return fmt.Errorf("unsupported vm policy: %s", policy)
 	}
-	privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		// 尝试使用PKCS8格式解析
-		privKeyInterface, err2 := x509.ParsePKCS8PrivateKey(block.Bytes)
-		if err2 != nil {
-			return "", fmt.Errorf("failed to parse private key: %v", err)
+	if policy == hwid.VMPolicyInstanceBound {
+		if b, err := hex.DecodeString(instanceUUID); err != nil || len(b) != 32 {
+			return errors.New("vm-instance-uuid policy requires the instance uuid hash")
 		}
-		var ok bool
-		privKey, ok = privKeyInterface.(*rsa.PrivateKey)
-		if !ok {
-			return "", fmt.Errorf("not an RSA private key")
-		}
-	}
-
-	// Create signing key
-	signingKey := jose.SigningKey{
-		Algorithm: jose.RS256,
-		Key:       privKey,
-	}
-
-	// Create signer
-	signer, err := jose.NewSigner(signingKey, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create signer: %v", err)
-	}
-
-	// Create claims
-	c := claims{
-		Iss:         "license-service",
-		Sub:         customer,
-		Customer:    customer,
-		Fingerprint: fingerprint,
-		Iat:         issuedAt.Unix(),
-		Exp:         exp,
-	}
-
-	// Sign claims
-	payload, err := json.Marshal(c)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal claims: %v", err)
 	}
-
-	jws, err := signer.Sign(payload)
-	if err != nil {
-		return "", fmt.Errorf("failed to sign claims: %v", err)
-	}
-
-	return jws.CompactSerialize()
+	return nil
 }
 
 // ------------------ Activate Handler ------------------
 
-func ActivateHandler(pubKeyPath, privateKeyPath string, db *database.DB) gin.HandlerFunc {
-	pub, err := loadPublicKey(pubKeyPath)
-	if err != nil {
-		panic(err)
-	}
+func ActivateHandler(issuer *Issuer, products Products, db *database.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			Customer        string `json:"customer"`
-			Fingerprint     string `json:"fingerprint"`
-			Description     string `json:"description"`
-			ValidityDays    int    `json:"validityDays"`
-			ValidityHours   int    `json:"validityHours"`
-			ValidityMinutes int    `json:"validityMinutes"`
-			ValiditySeconds int    `json:"validitySeconds"`
-			License         string // 用于内部存储生成的license，不从前端接收
+			Customer    string `json:"customer"`
+			Fingerprint string `json:"fingerprint"`
+			Description string `json:"description"`
+			Product     string `json:"product"`
+			// Components 可选的指纹组件哈希（gen_fingerprint -components 输出），提供时许可证按组件模糊匹配
+			Components hwid.Components `json:"components"`
+			// Binding 指纹的绑定方式（machine、host-machine-id、k8s-cluster、volume），为空表示 machine
+			Binding string `json:"binding"`
+			// VMPolicy 虚拟机策略，vm-instance-uuid 需要同时提供 InstanceUUID（/api/system/fingerprint 返回的 instanceUuid）
+			VMPolicy     string `json:"vmPolicy"`
+			InstanceUUID string `json:"instanceUuid"`
+			// Scheme 指纹算法版本，激活码带版本前缀时以前缀为准，为 0 表示 hwid.SchemeLegacy
+			Scheme int `json:"scheme"`
+			// FullFingerprint 完整的 256 位指纹（64 位 hex），提供时许可证校验完整摘要，Fingerprint 可以留空
+			FullFingerprint string `json:"fullFingerprint"`
+			// Salt 客户端指纹的加盐方式（/api/system/fingerprint 返回的 salt），为空时使用产品配置
+			Salt string `json:"salt"`
+			// Features 许可证授权的功能
+			Features        []string `json:"features"`
+			ValidityDays    int      `json:"validityDays"`
+			ValidityHours   int      `json:"validityHours"`
+			ValidityMinutes int      `json:"validityMinutes"`
+			ValiditySeconds int      `json:"validitySeconds"`
+			License         string   // 用于内部存储生成的license，不从前端接收
 		}
 		if err := c.BindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
@@ -183,12 +83,41 @@ func ActivateHandler(pubKeyPath, privateKeyPath string, db *database.DB) gin.Han
 
 		// 获取本机激活码并转 hex
 		fpCode := hwid.GetFingerprint() // XXXX-XXXX-XXXX-XXXX
-		_, err := DecodeActivationCodeToHex(fpCode)
+		_, err := hwid.DecodeActivationCodeToHex(fpCode)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode local fingerprint"})
 			return
 		}
 
+		product, err := products.Lookup(req.Product)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !hwid.ValidBinding(req.Binding) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported binding: " + req.Binding})
+			return
+		}
+
+		salt, err := product.saltFor(req.Salt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validateVMPolicy(req.VMPolicy, req.InstanceUUID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		for name, h := range req.Components {
+			if b, err := hex.DecodeString(h); err != nil || len(b) != 32 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid component hash: " + name})
+				return
+			}
+		}
+
 		// 验证至少有一个时间单位被设置
 		if req.ValidityDays == 0 && req.ValidityHours == 0 &&
 			req.ValidityMinutes == 0 && req.ValiditySeconds == 0 {
@@ -196,19 +125,62 @@ func ActivateHandler(pubKeyPath, privateKeyPath string, db *database.DB) gin.Han
 			return
 		}
 
-		// 校验激活码格式为XXXX-XXXX-XXXX-XXXX
-		if strings.Contains(req.Fingerprint, "-") {
-			// 验证格式是否为XXXX-XXXX-XXXX-XXXX
-			parts := strings.Split(req.Fingerprint, "-")
-			if len(parts) != 4 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "激活码格式不正确，应为XXXX-XXXX-XXXX-XXXX"})
+		// 只提供完整指纹时，由完整指纹生成显示用的激活码
+		if req.FullFingerprint != "" {
+			digest, err := decodeFullFingerprint(req.FullFingerprint)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			req.FullFingerprint = hex.EncodeToString(digest)
+			if req.Fingerprint == "" {
+				scheme := req.Scheme
+				if scheme == 0 {
+					scheme = hwid.SchemeLegacy
+				}
+				if req.Fingerprint, err = hwid.FormatActivationCode(scheme, digest); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+			}
+		}
+
+		// 统一大小写并纠正 0/1/8 等易混淆字符
+		req.Fingerprint = hwid.NormalizeActivationCode(req.Fingerprint)
+
+		// 带版本前缀的激活码（V2-XXXX-XXXX-XXXX-XXXX-C）先拆出指纹算法版本
+		scheme, code, err := hwid.SplitActivationCode(req.Fingerprint)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		prefixed := code != req.Fingerprint
+		if prefixed {
+			if req.Scheme != 0 && req.Scheme != scheme {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "scheme does not match the activation code"})
+				return
+			}
+		} else if req.Scheme != 0 {
+			if !hwid.ValidScheme(req.Scheme) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported fingerprint scheme: %d", req.Scheme)})
+				return
+			}
+			scheme = req.Scheme
+		}
+
+		// 校验激活码格式为XXXX-XXXX-XXXX-XXXX-C，激活时必须带校验位
+		if strings.Contains(code, "-") {
+			// 验证格式是否为XXXX-XXXX-XXXX-XXXX-C
+			parts := strings.Split(code, "-")
+			if len(parts) != 5 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "激活码格式不正确，应为XXXX-XXXX-XXXX-XXXX-C"})
 				return
 			}
 
-			// 验证每个部分是否为4个字符
-			for _, part := range parts {
-				if len(part) != 4 {
-					c.JSON(http.StatusBadRequest, gin.H{"error": "激活码格式不正确，每个部分应为4个字符"})
+			// 验证每个部分是否为4个字符，校验位为1个字符
+			for i, part := range parts {
+				if (i < 4 && len(part) != 4) || (i == 4 && len(part) != 1) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "激活码格式不正确，每个部分应为4个字符，校验位为1个字符"})
 					return
 				}
 
@@ -221,14 +193,14 @@ func ActivateHandler(pubKeyPath, privateKeyPath string, db *database.DB) gin.Han
 				}
 			}
 		} else {
-			// 如果没有连字符，验证是否为16个字符的base32编码
-			if len(req.Fingerprint) != 16 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "激活码长度不正确，应为16个字符"})
+			// 如果没有连字符，验证是否为16个字符的base32编码加校验位
+			if len(code) != 17 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "激活码长度不正确，应为16个字符加1个校验位"})
 				return
 			}
 
 			// 验证每个字符是否为有效的base32字符
-			for _, char := range req.Fingerprint {
+			for _, char := range code {
 				if !((char >= 'A' && char <= 'Z') || (char >= '2' && char <= '7')) {
 					c.JSON(http.StatusBadRequest, gin.H{"error": "激活码包含无效字符，只允许A-Z和2-7"})
 					return
@@ -236,20 +208,32 @@ func ActivateHandler(pubKeyPath, privateKeyPath string, db *database.DB) gin.Han
 			}
 		}
 
-		// 使用前端传入的原始fingerprint，不进行任何转换
-		fp := req.Fingerprint
-
-		// 如果fingerprint是带连字符的格式，转换为hex格式用于生成license
-		var fpForLicense string
-		if strings.Contains(req.Fingerprint, "-") {
-			h, err := DecodeActivationCodeToHex(req.Fingerprint)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to decode fingerprint: " + err.Error()})
-				return
+		// 校验位不匹配说明激活码抄错，给出可能的正确激活码
+		if err := hwid.RequireCheckChar(strings.ReplaceAll(code, "-", "")); err != nil {
+			var suggestions []string
+			if ce, ok := err.(*hwid.ChecksumError); ok {
+				for _, sug := range ce.Suggestions {
+					if prefixed {
+						sug = fmt.Sprintf("V%d-%s", scheme, sug)
+					}
+					suggestions = append(suggestions, sug)
+				}
 			}
-			fpForLicense = h
-		} else {
-			fpForLicense = req.Fingerprint
+			c.JSON(http.StatusBadRequest, gin.H{"error": "激活码校验失败，请检查是否抄错", "suggestions": suggestions})
+			return
+		}
+
+		// 无论是否带连字符和版本前缀，激活码都转换为hex格式用于生成license和激活记录
+		_, fpForLicense, err := hwid.DecodeActivationCodeStrict(req.Fingerprint)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to decode fingerprint: " + err.Error()})
+			return
+		}
+
+		// 完整指纹的前 80 位必须与激活码一致
+		if req.FullFingerprint != "" && !strings.HasPrefix(req.FullFingerprint, strings.ToLower(fpForLicense)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "full fingerprint does not match the activation code"})
+			return
 		}
 
 		// 计算过期时间
@@ -261,8 +245,25 @@ func ActivateHandler(pubKeyPath, privateKeyPath string, db *database.DB) gin.Han
 				time.Duration(req.ValiditySeconds)*time.Second,
 		).Unix()
 
-		// 生成新的license
-		newLicense, err := generateLicense(pubKeyPath, privateKeyPath, req.Customer, fpForLicense, now, exp)
+		// 生成新的license，签发器启动时已校验过密钥对，无需再用公钥重新验证；
+		// 绑定组件哈希时签发器不写入 fingerprint 和 fingerprint_full
+		cl := &verify.Claims{
+			Iss:             "license-service",
+			Sub:             verify.LicenseSubject,
+			Customer:        req.Customer,
+			Fingerprint:     fpForLicense,
+			Components:      req.Components,
+			Binding:         req.Binding,
+			VMPolicy:        req.VMPolicy,
+			InstanceUUID:    req.InstanceUUID,
+			FPScheme:        scheme,
+			FingerprintFull: req.FullFingerprint,
+			FPSalt:          salt,
+			Features:        req.Features,
+			Iat:             now.Unix(),
+			Exp:             exp,
+		}
+		newLicense, err := issuer.Issue(cl, product)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate license: " + err.Error()})
 			return
@@ -271,12 +272,6 @@ func ActivateHandler(pubKeyPath, privateKeyPath string, db *database.DB) gin.Han
 		// 使用新生成的license
 		req.License = newLicense
 
-		cl, err := verifyJWS(pub, req.License)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
 		// 不需要验证指纹匹配，因为我们使用前端传入的指纹
 		// 这允许为任何机器生成许可证，而不仅限于当前机器
 
@@ -285,7 +280,7 @@ func ActivateHandler(pubKeyPath, privateKeyPath string, db *database.DB) gin.Han
 		// 记录激活信息到数据库
 		if db != nil {
 			// 检查是否已有该指纹的激活记录
-			existingActivation, err := db.GetLicenseActivationByFingerprint(fp)
+			existingActivation, err := activeActivation(db, scheme, fpForLicense, req.Fingerprint)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				return
@@ -300,9 +295,10 @@ func ActivateHandler(pubKeyPath, privateKeyPath string, db *database.DB) gin.Han
 			// 创建新的激活记录
 			activation := &database.LicenseActivation{
 				Customer:    cl.Customer,
-				Fingerprint: fp, // 使用前端传入的指纹
+				Fingerprint: fpForLicense,
 				License:     req.License,
 				Description: req.Description,
+				Product:     product.Name,
 				IssuedAt:    time.Unix(cl.Iat, 0),
 				ExpiresAt:   time.Unix(cl.Exp, 0),
 				ActivatedAt: time.Now(),
@@ -320,67 +316,211 @@ func ActivateHandler(pubKeyPath, privateKeyPath string, db *database.DB) gin.Han
 	}
 }
 
+// activeActivation 查找同一指纹的有效激活记录。激活记录统一按 hex 指纹保存，
+// 旧版本的在线激活按激活码原文保存，因此同时查询规范的激活码和 codes 中的原文
+func activeActivation(db *database.DB, scheme int, fpHex string, codes ...string) (*database.LicenseActivation, error) {
+	keys := []string{fpHex}
+	if b, err := hex.DecodeString(fpHex); err == nil {
+		if code, err := hwid.FormatActivationCode(scheme, b); err == nil {
+			keys = append(keys, code)
+		}
+	}
+	for _, k := range append(keys, codes...) {
+		a, err := db.GetLicenseActivationByFingerprint(k)
+		if err != nil || a != nil {
+			return a, err
+		}
+	}
+	return nil, nil
+}
+
 // ------------------ License Middleware ------------------
 
-func LicenseMiddleware(pubKeyPath, storePath string, db *database.DB) gin.HandlerFunc {
-	pub, err := loadPublicKey(pubKeyPath)
+// DefaultAllow 服务自身许可证中间件默认放行的请求：激活接口、指纹查询接口和可信时间接口
+var DefaultAllow = []middleware.Rule{
+	{Methods: []string{http.MethodPost}, Pattern: "/api/license/activate"},
+	{Pattern: "/api/system/fingerprint"},
+	{Pattern: "/api/system/hwid"},
+	{Pattern: "/api/system/fingerprint/details"},
+	{Methods: []string{http.MethodGet}, Pattern: "/api/time"},
+}
+
+// statusError 许可证以外的错误，按指定状态码返回
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+// LicenseMiddlewareConfig 服务自身许可证中间件的配置
+type LicenseMiddlewareConfig struct {
+	PublicKeyPath string
+	StorePath     string // 数据库中没有许可证时读取的许可证文件
+	Product       Product
+	DB            *database.DB
+	// Source 许可证来源，为空时依次读取数据库中本机的激活记录和 StorePath
+	Source middleware.LicenseSource
+	Allow  []middleware.Rule // 为空时使用 DefaultAllow
+	// Interval 完整重新校验的间隔，为 0 时使用默认值，为负数时每个请求都重新校验
+	Interval time.Duration
+	// WatchInterval 检查数据库和许可证文件是否变化的间隔，为 0 时使用默认值
+	WatchInterval time.Duration
+	// ClockStatePath 时钟回拨检测的时间记录文件，为空时不检测
+	ClockStatePath string
+	// ClockSecret 派生时间记录 HMAC 密钥的密钥，为空时依次使用 Product 的 EncryptionKey、
+	// ShortKeySecret，都没有时退回到时间记录旁边的安装密钥
+	ClockSecret []byte
+	// StatusPath 非空时在该路径挂载许可证状态接口
+	StatusPath string
+	// Observers 接收许可证事件，事件总是会写入日志；每个请求都重新校验时不发送事件
+	Observers []middleware.Observer
+}
+
+// clockKey 时间记录的 HMAC 密钥，没有可用的密钥时返回 nil
+func clockKey(cfg LicenseMiddlewareConfig) []byte {
+	for _, secret := range [][]byte{cfg.ClockSecret, cfg.Product.EncryptionKey, cfg.Product.ShortKeySecret} {
+		if len(secret) > 0 {
+			return verify.DeriveClockKey(secret)
+		}
+	}
+	return nil
+}
+
+// LicenseMiddleware 校验服务自身的许可证，校验结果缓存到许可证变化、到期或重新校验间隔；
+// 返回的关闭函数停止后台检查许可证来源的协程
+func LicenseMiddleware(cfg LicenseMiddlewareConfig) (gin.HandlerFunc, func()) {
+	pub, err := verify.LoadPublicKey(cfg.PublicKeyPath)
 	if err != nil {
 		panic(err)
 	}
-	return func(c *gin.Context) {
-		// allow activation endpoint and hwid endpoint
-		if c.Request.Method == http.MethodPost && c.FullPath() == "/api/license/activate" {
-			c.Next()
-			return
-		}
-		if c.FullPath() == "/api/system/fingerprint" {
-			c.Next()
-			return
+	// 时间记录被篡改时拒绝所有请求，而不是让服务无法启动
+	var guard *verify.ClockGuard
+	var guardErr error
+	if cfg.ClockStatePath != "" {
+		guard, guardErr = verify.NewClockGuard(verify.ClockGuardConfig{
+			Path: cfg.ClockStatePath,
+			Key:  clockKey(cfg),
+		})
+		if guardErr != nil && !errors.Is(guardErr, verify.ErrClockTampered) {
+			panic(guardErr)
 		}
-
-		// 获取本机激活码并转 hex
-		fpCode := hwid.GetFingerprint() // XXXX-XXXX-XXXX-XXXX
-		localHex, err := DecodeActivationCodeToHex(fpCode)
+	}
+	verifier, err := verify.NewVerifier(verify.VerifierConfig{
+		PublicKeys:       []*rsa.PublicKey{pub},
+		EncryptionKey:    cfg.Product.EncryptionKey,
+		Product:          cfg.Product.Name,
+		RequireEncrypted: cfg.Product.Encrypt,
+		ClockGuard:       guard,
+	})
+	if err != nil {
+		panic(err)
+	}
+	allow := cfg.Allow
+	if len(allow) == 0 {
+		allow = DefaultAllow
+	}
+	source := cfg.Source
+	if source == nil {
+		source = middleware.Chain{ActivationSource{DB: cfg.DB}, middleware.FileSource{Path: cfg.StorePath}}
+	}
+	checker := middleware.VerifyChecker(verifier, source)
+	closer := func() {}
+	if cfg.Interval >= 0 {
+		cached, err := middleware.NewCachedChecker(middleware.CacheConfig{
+			Verifier:      verifier,
+			Source:        source,
+			Interval:      cfg.Interval,
+			WatchInterval: cfg.WatchInterval,
+			Observers:     append([]middleware.Observer{middleware.ObserverFunc(logLicenseEvent)}, cfg.Observers...),
+		})
 		if err != nil {
-			c.AbortWithStatusJSON(500, gin.H{"error": "failed to decode local fingerprint"})
-			return
+			panic(err)
 		}
+		checker, closer = cached, cached.Close
+	}
+	if guardErr != nil {
+		checker = middleware.CheckerFunc(func(ctx context.Context) (*verify.License, error) {
+			return nil, guardErr
+		})
+	}
+	m, err := middleware.New(middleware.Config{
+		Checker:    checker,
+		Allow:      allow,
+		Deny:       denyLicense,
+		StatusPath: cfg.StatusPath,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return ginmw.New(m), closer
+}
 
-		// 从数据库获取最新的有效license
-		var licenseStr string
-		if db != nil {
-			activation, err := db.GetActiveLicenseActivationByFingerprint(localHex)
-			if err != nil {
-				c.AbortWithStatusJSON(500, gin.H{"error": "database error"})
-				return
-			}
-			if activation != nil {
-				licenseStr = activation.License
-			}
-		}
+// logLicenseEvent 把许可证事件写入日志，提醒运维人员续期
+func logLicenseEvent(e middleware.Event) {
+	switch e.Type {
+	case middleware.EventExpiring:
+		log.Printf("license %s for %s expires in %d days (%s)", e.License.ID, e.License.Customer,
+			int(e.Remaining.Hours()/24), e.License.ExpiresAt.Format(time.RFC3339))
+	case middleware.EventGrace:
+		log.Printf("license %s for %s expired, grace period ends %s", e.License.ID, e.License.Customer,
+			e.License.ValidUntil.Format(time.RFC3339))
+	case middleware.EventRenewed:
+		log.Printf("license renewed: %s for %s, expires %s", e.License.ID, e.License.Customer,
+			e.License.ExpiresAt.Format(time.RFC3339))
+	default:
+		log.Printf("license %s: %v", e.Type, e.Err)
+	}
+}
 
-		// 如果数据库中没有找到，尝试从文件读取（向后兼容）
-		if licenseStr == "" {
-			b, err := os.ReadFile(storePath)
-			if err != nil {
-				c.AbortWithStatusJSON(403, gin.H{"error": "no license, please activate"})
-				return
-			}
-			licenseStr = string(b)
-		}
+// ActivationSource 数据库中本机（按默认绑定方式）的有效激活记录
+type ActivationSource struct {
+	DB *database.DB
+}
 
-		cl, err := verifyJWS(pub, licenseStr)
-		if err != nil {
-			c.AbortWithStatusJSON(403, gin.H{"error": "invalid license: " + err.Error()})
-			return
-		}
+func (s ActivationSource) Load(ctx context.Context) ([]middleware.Candidate, error) {
+	if s.DB == nil {
+		return nil, nil
+	}
+	fpCode, err := hwid.FingerprintFor(hwid.DefaultBinding()) // XXXX-XXXX-XXXX-XXXX
+	if err != nil {
+		return nil, &statusError{http.StatusInternalServerError, "failed to compute local fingerprint: " + err.Error()}
+	}
+	localHex, err := hwid.DecodeActivationCodeToHex(fpCode)
+	if err != nil {
+		return nil, &statusError{http.StatusInternalServerError, "failed to decode local fingerprint"}
+	}
+	activation, err := s.DB.GetActiveLicenseActivationByFingerprint(localHex)
+	if err != nil {
+		return nil, &statusError{http.StatusInternalServerError, "database error"}
+	}
+	if activation == nil {
+		return nil, nil
+	}
+	return []middleware.Candidate{{Content: activation.License, Source: fmt.Sprintf("database:activation/%d", activation.ID)}}, nil
+}
 
-		if cl.Fingerprint != "" && cl.Fingerprint != localHex {
-			c.AbortWithStatusJSON(403, gin.H{"error": "fingerprint mismatch"})
-			return
-		}
-		fmt.Println("license check ok")
-		c.Set("license.customer", cl.Customer)
-		c.Next()
+// Version 只查询激活记录的版本标识，不计算本机指纹
+func (s ActivationSource) Version(ctx context.Context) (string, error) {
+	if s.DB == nil {
+		return "", nil
 	}
+	return s.DB.ActiveLicenseActivationsVersion()
+}
+
+// denyLicense 内部错误按状态码返回，其余按许可证无效返回 403
+func denyLicense(w http.ResponseWriter, r *http.Request, err error) {
+	var se *statusError
+	switch {
+	case errors.As(err, &se):
+		writeError(w, se.status, se.msg)
+	default:
+		middleware.DefaultDeny(w, r, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(gin.H{"error": msg})
 }
@@ -0,0 +1,136 @@
+package license
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"license/hwid"
+	"license/internal/database"
+	"license/verify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 对比服务自身许可证中间件每个请求都重新校验（旧实现）与缓存校验结果的开销：
+// 两者都按本机指纹查询 SQLite 中的激活记录，找不到时读取许可证文件
+func BenchmarkLicenseMiddleware(b *testing.B) {
+	cfg := setupMiddlewareBench(b)
+	gin.SetMode(gin.ReleaseMode)
+	uncached := cfg
+	uncached.Interval = -1
+	for _, bc := range []struct {
+		name string
+		cfg  *LicenseMiddlewareConfig
+	}{
+		{"no license check", nil},
+		{"per-request verification", &uncached},
+		{"cached verification", &cfg},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			h := benchHandler(b, bc.cfg)
+			if code := serve(h); code != http.StatusOK {
+				b.Fatalf("license check failed with status %d", code)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				serve(h)
+			}
+		})
+	}
+}
+
+// setupMiddlewareBench 生成密钥，为本机签发许可证并写入数据库和许可证文件
+func setupMiddlewareBench(b *testing.B) LicenseMiddlewareConfig {
+	b.Helper()
+	dir := b.TempDir()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		b.Fatal(err)
+	}
+	privPath := filepath.Join(dir, "private.pem")
+	pubPath := filepath.Join(dir, "public.pem")
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		b.Fatal(err)
+	}
+	issuer, err := NewIssuer(IssuerConfig{SignerType: SignerTypeFile, PrivateKeyPath: privPath, PublicKeyPath: pubPath})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer issuer.Close()
+
+	fpCode, err := hwid.FingerprintFor(hwid.DefaultBinding())
+	if err != nil {
+		b.Fatal(err)
+	}
+	fp, err := hwid.DecodeActivationCodeToHex(fpCode)
+	if err != nil {
+		b.Fatal(err)
+	}
+	now := time.Now().UTC()
+	cl := &verify.Claims{Iss: "bench", Sub: verify.LicenseSubject, Customer: "bench", Fingerprint: fp, Iat: now.Unix(), Exp: now.AddDate(0, 0, 30).Unix()}
+	token, err := issuer.Issue(cl, Product{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	storePath := filepath.Join(dir, "license.lic")
+	if err := os.WriteFile(storePath, []byte(verify.EncodeLicenseFile(token, "")), 0600); err != nil {
+		b.Fatal(err)
+	}
+	db, err := database.NewDB(filepath.Join(dir, "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	err = db.InsertLicenseActivation(&database.LicenseActivation{
+		Customer:    cl.Customer,
+		Fingerprint: fp,
+		License:     token,
+		IssuedAt:    now,
+		ExpiresAt:   time.Unix(cl.Exp, 0),
+		ActivatedAt: now,
+		IsActive:    true,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return LicenseMiddlewareConfig{
+		PublicKeyPath: pubPath,
+		StorePath:     storePath,
+		DB:            db,
+		WatchInterval: -1,
+	}
+}
+
+// benchHandler cfg 为空时不挂载许可证中间件，作为路由和 httptest 本身开销的基准
+func benchHandler(b *testing.B, cfg *LicenseMiddlewareConfig) http.Handler {
+	r := gin.New()
+	if cfg != nil {
+		h, closeLicense := LicenseMiddleware(*cfg)
+		b.Cleanup(closeLicense)
+		r.Use(h)
+	}
+	r.GET("/api/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func serve(h http.Handler) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/ping", nil))
+	return w.Code
+}
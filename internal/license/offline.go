@@ -0,0 +1,267 @@
+package license
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"license/hwid"
+	"license/internal/database"
+	"license/verify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ------------------ Offline Activation ------------------
+
+// 离线激活流程：
+//  1. 客户端生成激活请求文件（机器指纹、产品、随机 nonce），并在本地保留一份待处理请求
+//  2. 操作员把请求文件带到服务端（或 cmd/gen_license -request），签发绑定该 nonce 的许可证
+//  3. 客户端导入响应许可证，nonce 或指纹与待处理请求不一致时拒绝
+//
+// 请求文件不签名，只用文本封装的 CRC-24 防止传输损坏
+const (
+	activationRequestLabel   = "ACTIVATION REQUEST"
+	activationRequestVersion = 1
+)
+
+// ActivationRequest 离线激活请求
+type ActivationRequest struct {
+	Product         string          `json:"product,omitempty"`
+	Fingerprint     string          `json:"fingerprint"`               // 激活码对应的 hex
+	FullFingerprint string          `json:"fullFingerprint,omitempty"` // 完整的 256 位指纹（hex）
+	Components      hwid.Components `json:"components,omitempty"`      // 各指纹组件的哈希
+	Binding         string          `json:"binding,omitempty"`         // 指纹的绑定方式，为空表示 machine
+	Scheme          int             `json:"scheme,omitempty"`          // 指纹算法版本
+	Salt            string          `json:"salt,omitempty"`            // 指纹的加盐方式
+	SaltCustomer    string          `json:"saltCustomer,omitempty"`    // 按客户加盐时的客户名称
+	Hostname        string          `json:"hostname,omitempty"`        // 仅供操作员辨认机器
+	VM              hwid.VMInfo     `json:"vm"`                        // 虚拟机检测结果，供操作员选择虚拟机策略
+	InstanceUUID    string          `json:"instanceUuid,omitempty"`    // 虚拟机实例 UUID 哈希
+	Nonce           string          `json:"nonce"`
+	CreatedAt       int64           `json:"createdAt"`
+}
+
+// NewActivationRequest 为本机生成离线激活请求，binding 为空时绑定本机硬件
+func NewActivationRequest(product, binding string) (*ActivationRequest, error) {
+	fpCode, err := hwid.FingerprintFor(binding)
+	if err != nil {
+		return nil, err
+	}
+	scheme, fpHex, err := hwid.DecodeActivationCode(fpCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode local fingerprint: %v", err)
+	}
+	digest, err := hwid.FingerprintDigestFor(binding, scheme)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	req := &ActivationRequest{
+		Product:         product,
+		Fingerprint:     fpHex,
+		FullFingerprint: hex.EncodeToString(digest),
+		Binding:         binding,
+		Scheme:          scheme,
+		Hostname:        hostname,
+		VM:              hwid.DetectVM("/"),
+		InstanceUUID:    hwid.InstanceUUIDHash(),
+		Nonce:           hex.EncodeToString(nonce),
+		CreatedAt:       time.Now().UTC().Unix(),
+	}
+	req.Salt, req.SaltCustomer = hwid.Salting()
+	// 组件哈希只对本机硬件绑定有意义
+	if binding == "" || binding == hwid.BindingMachine {
+		req.Components = hwid.GetComponents()
+	}
+	return req, nil
+}
+
+// EncodeActivationRequest 将激活请求封装为文本文件内容
+func EncodeActivationRequest(req *ActivationRequest) (string, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	return verify.EncodeArmor(activationRequestLabel, activationRequestVersion, req.Product, b), nil
+}
+
+// DecodeActivationRequest 解析激活请求文件
+func DecodeActivationRequest(data string) (*ActivationRequest, error) {
+	version, _, raw, err := verify.DecodeArmor(activationRequestLabel, data)
+	if err != nil {
+		return nil, err
+	}
+	if version != activationRequestVersion {
+		return nil, fmt.Errorf("unsupported activation request version: %d", version)
+	}
+	var req ActivationRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, fmt.Errorf("activation request is damaged: %v", err)
+	}
+	if req.Fingerprint == "" || req.Nonce == "" {
+		return nil, errors.New("activation request is missing fingerprint or nonce")
+	}
+	if !hwid.ValidBinding(req.Binding) {
+		return nil, fmt.Errorf("unsupported binding: %s", req.Binding)
+	}
+	if req.Scheme != 0 && !hwid.ValidScheme(req.Scheme) {
+		return nil, fmt.Errorf("unsupported fingerprint scheme: %d", req.Scheme)
+	}
+	if !hwid.ValidSaltMode(req.Salt) {
+		return nil, fmt.Errorf("unsupported fingerprint salt: %s", req.Salt)
+	}
+	if req.FullFingerprint != "" {
+		if _, err := decodeFullFingerprint(req.FullFingerprint); err != nil || !strings.HasPrefix(req.FullFingerprint, req.Fingerprint) {
+			return nil, errors.New("activation request has an invalid full fingerprint")
+		}
+	}
+	return &req, nil
+}
+
+// AcceptActivationResponse 校验服务端返回的许可证是否对应本机待处理的请求，返回许可证 token
+func AcceptActivationResponse(pub *rsa.PublicKey, encryptionKey []byte, pending *ActivationRequest, response string) (string, error) {
+	lf, err := verify.DecodeLicenseFile(response)
+	if err != nil {
+		return "", err
+	}
+	cl, err := verify.Parse(pub, lf.Token, encryptionKey)
+	if err != nil {
+		return "", err
+	}
+	if cl.Nonce != pending.Nonce {
+		return "", errors.New("license does not answer the outstanding activation request")
+	}
+	// 绑定组件哈希的许可证不带指纹，比较组件哈希
+	if cl.ComponentBound() {
+		if !maps.Equal(cl.Components, pending.Components) {
+			return "", errors.New("fingerprint components mismatch")
+		}
+	} else if cl.Fingerprint != pending.Fingerprint {
+		return "", errors.New("fingerprint mismatch")
+	}
+	return lf.Token, nil
+}
+
+// ------------------ Offline Activate Handler ------------------
+
+// OfflineActivateHandler 处理离线激活请求文件，返回绑定请求 nonce 的许可证文件
+func OfflineActivateHandler(issuer *Issuer, products Products, db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Request      string   `json:"request"` // 激活请求文件内容
+			Customer     string   `json:"customer"`
+			Description  string   `json:"description"`
+			ValidityDays int      `json:"validityDays"`
+			VMPolicy     string   `json:"vmPolicy"` // 虚拟机策略，vm-instance-uuid 使用请求文件中的实例 UUID
+			Features     []string `json:"features"` // 许可证授权的功能
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		if req.ValidityDays <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "validityDays must be positive"})
+			return
+		}
+
+		ar, err := DecodeActivationRequest(req.Request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateVMPolicy(req.VMPolicy, ar.InstanceUUID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		product, err := products.Lookup(ar.Product)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 请求文件如实记录了客户端的加盐方式，必须与产品配置一致
+		if product.Name != "" && ar.Salt != product.FingerprintSalt {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("fingerprint salt %q does not match product %s", ar.Salt, product.Name)})
+			return
+		}
+		if ar.Salt == hwid.SaltCustomer && ar.SaltCustomer != req.Customer {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "customer does not match the salted fingerprint: " + ar.SaltCustomer})
+			return
+		}
+
+		now := time.Now().UTC()
+		cl := &verify.Claims{
+			Iss:             "license-service",
+			Sub:             verify.LicenseSubject,
+			Customer:        req.Customer,
+			Fingerprint:     ar.Fingerprint,
+			Components:      ar.Components,
+			Binding:         ar.Binding,
+			VMPolicy:        req.VMPolicy,
+			InstanceUUID:    ar.InstanceUUID,
+			FPScheme:        ar.Scheme,
+			FingerprintFull: ar.FullFingerprint,
+			FPSalt:          ar.Salt,
+			Features:        req.Features,
+			Nonce:           ar.Nonce,
+			Iat:             now.Unix(),
+			Exp:             now.AddDate(0, 0, req.ValidityDays).Unix(),
+		}
+		token, err := issuer.Issue(cl, product)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate license: " + err.Error()})
+			return
+		}
+
+		// 记录激活信息到数据库，与在线激活相同，同一指纹只能有一条有效的激活记录
+		if db != nil {
+			scheme := ar.Scheme
+			if scheme == 0 {
+				scheme = hwid.SchemeLegacy
+			}
+			existing, err := activeActivation(db, scheme, ar.Fingerprint)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if existing != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "当前机器码已经存在，不能重复激活"})
+				return
+			}
+			activation := &database.LicenseActivation{
+				Customer:    cl.Customer,
+				Fingerprint: ar.Fingerprint,
+				License:     token,
+				Description: req.Description,
+				Product:     product.Name,
+				IssuedAt:    time.Unix(cl.Iat, 0),
+				ExpiresAt:   time.Unix(cl.Exp, 0),
+				ActivatedAt: time.Now(),
+				IsActive:    true,
+			}
+			if err := db.InsertLicenseActivation(activation); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record activation"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":        true,
+			"customer":       cl.Customer,
+			"exp":            cl.Exp,
+			"licenseContent": verify.EncodeLicenseFile(token, product.Name),
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package license
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// ------------------ PKCS#11 签名器 ------------------
+
+// PKCS11Config PKCS#11 令牌配置
+type PKCS11Config struct {
+	Module     string // PKCS#11 模块路径，如 /usr/lib/softhsm/libsofthsm2.so
+	TokenLabel string // 令牌标签
+	Pin        string // 用户 PIN，为空时读取环境变量 LICENSE_PKCS11_PIN
+	KeyLabel   string // 私钥对象的 CKA_LABEL
+}
+
+// PKCS11Signer 私钥保存在 HSM/令牌中，签名运算在设备内完成
+type PKCS11Signer struct {
+	*cryptoSigner
+	key *pkcs11Key
+}
+
+// NewPKCS11Signer 打开令牌会话并定位签名私钥
+func NewPKCS11Signer(cfg PKCS11Config) (*PKCS11Signer, error) {
+	if cfg.Module == "" {
+		return nil, errors.New("pkcs11 module path is required")
+	}
+	if cfg.KeyLabel == "" {
+		return nil, errors.New("pkcs11 key label is required")
+	}
+	pin := cfg.Pin
+	if pin == "" {
+		pin = os.Getenv("LICENSE_PKCS11_PIN")
+	}
+
+	ctx, err := acquireModule(cfg.Module)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &pkcs11Key{ctx: ctx, module: cfg.Module}
+	if err := key.open(cfg.TokenLabel, pin, cfg.KeyLabel); err != nil {
+		key.close()
+		return nil, err
+	}
+
+	cs, err := newCryptoSigner(key)
+	if err != nil {
+		key.close()
+		return nil, err
+	}
+	return &PKCS11Signer{cryptoSigner: cs, key: key}, nil
+}
+
+// Close 关闭会话，模块的最后一个会话关闭后才释放模块
+func (s *PKCS11Signer) Close() error {
+	return s.key.close()
+}
+
+// PKCS#11 模块在一个进程内只能 C_Initialize 一次，重复初始化返回 CKR_CRYPTOKI_ALREADY_INITIALIZED，
+// C_Finalize 会关闭该模块的所有会话；因此同一模块共用一个 Ctx，按引用计数释放，
+// 重新加载密钥时先打开新会话再关闭旧会话，引用计数不会归零
+var (
+	modulesMu sync.Mutex
+	modules   = map[string]*pkcs11Module{}
+)
+
+type pkcs11Module struct {
+	ctx  *pkcs11.Ctx
+	refs int
+}
+
+func acquireModule(path string) (*pkcs11.Ctx, error) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	if m, ok := modules[path]; ok {
+		m.refs++
+		return m.ctx, nil
+	}
+	ctx := pkcs11.New(path)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load pkcs11 module: %s", path)
+	}
+	if err := ctx.Initialize(); err != nil && !isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("failed to initialize pkcs11 module: %v", err)
+	}
+	modules[path] = &pkcs11Module{ctx: ctx, refs: 1}
+	return ctx, nil
+}
+
+func releaseModule(path string) error {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	m, ok := modules[path]
+	if !ok {
+		return nil
+	}
+	if m.refs--; m.refs > 0 {
+		return nil
+	}
+	delete(modules, path)
+	err := m.ctx.Finalize()
+	m.ctx.Destroy()
+	return err
+}
+
+func isPKCS11Error(err error, code uint) bool {
+	var e pkcs11.Error
+	return errors.As(err, &e) && uint(e) == code
+}
+
+// pkcs11Key 实现 crypto.Signer，PKCS#11 会话不是并发安全的，签名时加锁
+type pkcs11Key struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	module  string
+	session pkcs11.SessionHandle
+	priv    pkcs11.ObjectHandle
+	pub     *rsa.PublicKey
+}
+
+// sha256DigestInfo CKM_RSA_PKCS 需要调用方自行拼接 DigestInfo 前缀
+var sha256DigestInfo = []byte{0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20}
+
+func (k *pkcs11Key) open(tokenLabel, pin, keyLabel string) error {
+	slot, err := k.findSlot(tokenLabel)
+	if err != nil {
+		return err
+	}
+	k.session, err = k.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("failed to open pkcs11 session: %v", err)
+	}
+	// 登录状态属于整个应用，重新加载时旧会话仍然在线，新会话直接沿用
+	if err := k.ctx.Login(k.session, pkcs11.CKU_USER, pin); err != nil && !isPKCS11Error(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		return fmt.Errorf("failed to login to pkcs11 token: %v", err)
+	}
+
+	k.priv, err = k.findObject(pkcs11.CKO_PRIVATE_KEY, keyLabel)
+	if err != nil {
+		return err
+	}
+
+	// RSA 私钥对象通常可以直接读取模数和公钥指数，读取失败时再查找同标签的公钥对象
+	k.pub, err = k.readPublicKey(k.priv)
+	if err != nil {
+		pubObj, err2 := k.findObject(pkcs11.CKO_PUBLIC_KEY, keyLabel)
+		if err2 != nil {
+			return fmt.Errorf("failed to read public key: %v", err)
+		}
+		if k.pub, err = k.readPublicKey(pubObj); err != nil {
+			return fmt.Errorf("failed to read public key: %v", err)
+		}
+	}
+	return nil
+}
+
+func (k *pkcs11Key) findSlot(tokenLabel string) (uint, error) {
+	slots, err := k.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list pkcs11 slots: %v", err)
+	}
+	for _, slot := range slots {
+		if tokenLabel == "" {
+			return slot, nil
+		}
+		info, err := k.ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(info.Label) == tokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("pkcs11 token not found: %s", tokenLabel)
+}
+
+func (k *pkcs11Key) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := k.ctx.FindObjectsInit(k.session, template); err != nil {
+		return 0, fmt.Errorf("failed to search pkcs11 objects: %v", err)
+	}
+	objs, _, err := k.ctx.FindObjects(k.session, 1)
+	if finErr := k.ctx.FindObjectsFinal(k.session); err == nil {
+		err = finErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to search pkcs11 objects: %v", err)
+	}
+	if len(objs) == 0 {
+		return 0, fmt.Errorf("pkcs11 key not found: %s", label)
+	}
+	return objs[0], nil
+}
+
+func (k *pkcs11Key) readPublicKey(obj pkcs11.ObjectHandle) (*rsa.PublicKey, error) {
+	attrs, err := k.ctx.GetAttributeValue(k.session, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var n, e *big.Int
+	for _, a := range attrs {
+		switch a.Type {
+		case pkcs11.CKA_MODULUS:
+			n = new(big.Int).SetBytes(a.Value)
+		case pkcs11.CKA_PUBLIC_EXPONENT:
+			e = new(big.Int).SetBytes(a.Value)
+		}
+	}
+	if n == nil || e == nil || n.Sign() == 0 || !e.IsInt64() {
+		return nil, errors.New("incomplete rsa public key attributes")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k *pkcs11Key) Public() crypto.PublicKey {
+	return k.pub
+}
+
+func (k *pkcs11Key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 || len(digest) != crypto.SHA256.Size() {
+		return nil, errors.New("pkcs11 signer only supports SHA-256 digests")
+	}
+
+	var mech *pkcs11.Mechanism
+	var data []byte
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		params := pkcs11.NewPSSParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, uint(crypto.SHA256.Size()))
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, params)
+		data = digest
+	} else {
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+		data = append(append([]byte{}, sha256DigestInfo...), digest...)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.ctx.SignInit(k.session, []*pkcs11.Mechanism{mech}, k.priv); err != nil {
+		return nil, fmt.Errorf("pkcs11 sign init failed: %v", err)
+	}
+	sig, err := k.ctx.Sign(k.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11 sign failed: %v", err)
+	}
+	return sig, nil
+}
+
+// close 只关闭本会话；C_Logout 会让同一令牌上的其他会话一起注销，不能在重新加载时调用，
+// 令牌的最后一个会话关闭后自动注销
+func (k *pkcs11Key) close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.ctx == nil {
+		return nil
+	}
+	if k.session != 0 {
+		k.ctx.CloseSession(k.session)
+		k.session = 0
+	}
+	k.ctx = nil
+	return releaseModule(k.module)
+}
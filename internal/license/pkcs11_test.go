@@ -0,0 +1,222 @@
+package license
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"license/verify"
+
+	"github.com/square/go-jose/v3"
+)
+
+// softHSMConfig 本地 SoftHSM2 令牌的配置，先用 softhsm_setup.sh 导入签名私钥，再设置
+//
+//	SOFTHSM2_MODULE=/usr/lib/softhsm/libsofthsm2.so
+//	LICENSE_PKCS11_TOKEN=license
+//	LICENSE_PKCS11_PIN=1234
+//
+// LICENSE_PKCS11_KEY 为私钥标签，默认与 softhsm_setup.sh 一致；未设置令牌时跳过测试
+func softHSMConfig(t *testing.T) PKCS11Config {
+	t.Helper()
+	module, token := os.Getenv("SOFTHSM2_MODULE"), os.Getenv("LICENSE_PKCS11_TOKEN")
+	if module == "" || token == "" || os.Getenv("LICENSE_PKCS11_PIN") == "" {
+		t.Skip("SOFTHSM2_MODULE, LICENSE_PKCS11_TOKEN and LICENSE_PKCS11_PIN are not set")
+	}
+	key := os.Getenv("LICENSE_PKCS11_KEY")
+	if key == "" {
+		key = "license-signing"
+	}
+	// PIN 留空，与服务端一样从环境变量读取
+	return PKCS11Config{Module: module, TokenLabel: token, KeyLabel: key}
+}
+
+func TestNewPKCS11SignerConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  PKCS11Config
+	}{
+		{"no module", PKCS11Config{KeyLabel: "license-signing"}},
+		{"no key label", PKCS11Config{Module: "/usr/lib/softhsm/libsofthsm2.so"}},
+		{"missing module", PKCS11Config{Module: "/nonexistent/libpkcs11.so", KeyLabel: "license-signing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if s, err := NewPKCS11Signer(tt.cfg); err == nil {
+				s.Close()
+				t.Fatal("NewPKCS11Signer() should fail")
+			}
+		})
+	}
+}
+
+func TestPKCS11Signer(t *testing.T) {
+	cfg := softHSMConfig(t)
+	s, err := NewPKCS11Signer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	pub, ok := s.Public().Key.(*rsa.PublicKey)
+	if !ok {
+		t.Fatalf("unexpected public key %T", s.Public().Key)
+	}
+
+	for _, alg := range []jose.SignatureAlgorithm{jose.RS256, jose.PS256} {
+		t.Run(string(alg), func(t *testing.T) {
+			signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: s}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 会话不是并发安全的，签名器内部加锁
+			var wg sync.WaitGroup
+			errs := make(chan error, 8)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					jws, err := signer.Sign([]byte("payload"))
+					if err == nil {
+						_, err = jws.Verify(pub)
+					}
+					errs <- err
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPKCS11SignerErrors(t *testing.T) {
+	cfg := softHSMConfig(t)
+	tests := []struct {
+		name   string
+		modify func(c *PKCS11Config)
+	}{
+		{"unknown token", func(c *PKCS11Config) { c.TokenLabel = "no-such-token" }},
+		{"unknown key", func(c *PKCS11Config) { c.KeyLabel = "no-such-key" }},
+		{"wrong pin", func(c *PKCS11Config) { c.Pin = "wrong-pin" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cfg
+			tt.modify(&c)
+			if s, err := NewPKCS11Signer(c); err == nil {
+				s.Close()
+				t.Fatal("NewPKCS11Signer() should fail")
+			}
+		})
+	}
+	// 失败后令牌仍然可用
+	s, err := NewPKCS11Signer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
+
+// writePublicKey 把公钥写入 PEM 文件
+func writePublicKey(t *testing.T, pub *rsa.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "public.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// moduleRefs 模块当前的引用计数，未加载时为 0
+func moduleRefs(path string) int {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	if m, ok := modules[path]; ok {
+		return m.refs
+	}
+	return 0
+}
+
+func TestPKCS11Issuer(t *testing.T) {
+	p11 := softHSMConfig(t)
+	s, err := NewPKCS11Signer(p11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := s.Public().Key.(*rsa.PublicKey)
+	s.Close()
+	if n := moduleRefs(p11.Module); n != 0 {
+		t.Fatalf("module has %d references after the signer was closed", n)
+	}
+
+	// 令牌中的私钥与配置的公钥不配对时无法启动
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewIssuer(IssuerConfig{SignerType: SignerTypePKCS11, PublicKeyPath: writePublicKey(t, &other.PublicKey), PKCS11: p11}); err == nil {
+		t.Fatal("NewIssuer() should reject a public key that does not match the token key")
+	}
+	if n := moduleRefs(p11.Module); n != 0 {
+		t.Fatalf("module has %d references after a failed start", n)
+	}
+
+	issuer, err := NewIssuer(IssuerConfig{SignerType: SignerTypePKCS11, PublicKeyPath: writePublicKey(t, pub), PKCS11: p11})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := verify.NewVerifier(verify.VerifierConfig{PublicKeys: []*rsa.PublicKey{pub}, SkipFingerprint: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := func() {
+		t.Helper()
+		now := time.Now()
+		token, err := issuer.Issue(&verify.Claims{
+			Iss:      "test",
+			Sub:      verify.LicenseSubject,
+			Customer: "acme",
+			Iat:      now.Unix(),
+			Exp:      now.Add(time.Hour).Unix(),
+		}, Product{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.VerifyToken(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	issue()
+
+	// 重新加载时先打开新会话再关闭旧会话，共用的模块不会被 C_Finalize，登录状态保持
+	for i := 0; i < 3; i++ {
+		if err := issuer.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		if n := moduleRefs(p11.Module); n != 1 {
+			t.Fatalf("module has %d references after reload, want 1", n)
+		}
+		issue()
+	}
+
+	if err := issuer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := moduleRefs(p11.Module); n != 0 {
+		t.Fatalf("module has %d references after the issuer was closed", n)
+	}
+}
@@ -0,0 +1,123 @@
+package license
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"license/hwid"
+
+	"github.com/square/go-jose/v3"
+)
+
+// ------------------ Product ------------------
+
+// Product 产品配置，决定为该产品签发的许可证是否加密
+type Product struct {
+	Name           string
+	ID             uint16 // 短许可证密钥中使用的产品编号
+	Encrypt        bool
+	EncryptionKey  []byte // A256GCM 对称密钥（32 字节），随产品客户端一起分发
+	ShortKeySecret []byte // 短许可证密钥的 HMAC 密钥，随产品客户端一起分发
+	// FingerprintSalt 产品客户端的指纹加盐方式（product、customer），为空表示不加盐，
+	// 盐本身只随客户端分发，服务端只记录加盐方式
+	FingerprintSalt string
+}
+
+// ProductConfig 产品配置的原始形式，密钥均为 base64 编码
+type ProductConfig struct {
+	Name            string
+	ID              uint16
+	Encrypt         bool
+	EncryptionKey   string
+	ShortKeySecret  string
+	FingerprintSalt string
+}
+
+// Products 按名称索引的产品配置
+type Products map[string]Product
+
+// NewProduct 解析产品配置，EncryptionKey 必须是 32 字节
+func NewProduct(cfg ProductConfig) (Product, error) {
+	p := Product{Name: cfg.Name, ID: cfg.ID, Encrypt: cfg.Encrypt, FingerprintSalt: cfg.FingerprintSalt}
+	if !hwid.ValidSaltMode(cfg.FingerprintSalt) {
+		return Product{}, fmt.Errorf("product %s: unsupported fingerprint salt: %s", cfg.Name, cfg.FingerprintSalt)
+	}
+	if cfg.EncryptionKey != "" {
+		key, err := DecodeEncryptionKey(cfg.EncryptionKey)
+		if err != nil {
+			return Product{}, fmt.Errorf("product %s: %v", cfg.Name, err)
+		}
+		p.EncryptionKey = key
+	}
+	if p.Encrypt && p.EncryptionKey == nil {
+		return Product{}, fmt.Errorf("product %s: encryption enabled but no encryption key", cfg.Name)
+	}
+	if cfg.ShortKeySecret != "" {
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(cfg.ShortKeySecret))
+		if err != nil || len(secret) < 16 {
+			return Product{}, fmt.Errorf("product %s: short key secret must be base64 of at least 16 bytes", cfg.Name)
+		}
+		p.ShortKeySecret = secret
+	}
+	return p, nil
+}
+
+// Lookup 查找产品，name 为空表示不区分产品
+func (ps Products) Lookup(name string) (Product, error) {
+	if name == "" {
+		return Product{}, nil
+	}
+	p, ok := ps[name]
+	if !ok {
+		return Product{}, fmt.Errorf("unknown product: %s", name)
+	}
+	return p, nil
+}
+
+// saltFor 确定许可证的指纹加盐方式：产品配置了加盐方式时以产品为准，
+// 客户端声明的加盐方式与产品不一致说明指纹不是用该产品的盐计算的
+func (p Product) saltFor(mode string) (string, error) {
+	if !hwid.ValidSaltMode(mode) {
+		return "", fmt.Errorf("unsupported fingerprint salt: %s", mode)
+	}
+	if p.Name == "" {
+		return mode, nil
+	}
+	if mode != "" && mode != p.FingerprintSalt {
+		return "", fmt.Errorf("fingerprint salt %q does not match product %s", mode, p.Name)
+	}
+	return p.FingerprintSalt, nil
+}
+
+// DecodeEncryptionKey 解码 base64 编码的产品加密密钥，必须是 32 字节
+func DecodeEncryptionKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+	return key, nil
+}
+
+// ------------------ JWE ------------------
+
+// EncryptLicense 先签名后加密：把 JWS 作为嵌套 JWT 放入 JWE，Issuer 和 gen_license 共用
+func EncryptLicense(jwsCompact string, key []byte) (string, error) {
+	enc, err := jose.NewEncrypter(
+		jose.A256GCM,
+		jose.Recipient{Algorithm: jose.DIRECT, Key: key},
+		(&jose.EncrypterOptions{}).WithContentType("JWT"),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create encrypter: %v", err)
+	}
+	obj, err := enc.Encrypt([]byte(jwsCompact))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt license: %v", err)
+	}
+	return obj.CompactSerialize()
+}
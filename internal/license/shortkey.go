@@ -0,0 +1,63 @@
+package license
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"license/hwid"
+	"license/verify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ------------------ Short Key Handler ------------------
+
+// ShortKeyHandler 签发短许可证密钥
+func ShortKeyHandler(products Products) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Product      string `json:"product"`
+			Fingerprint  string `json:"fingerprint"`
+			ValidityDays int    `json:"validityDays"`
+			Features     uint16 `json:"features"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		if req.ValidityDays <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "validityDays must be positive"})
+			return
+		}
+
+		product, err := products.Lookup(req.Product)
+		if err != nil || product.ShortKeySecret == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "product does not support short keys"})
+			return
+		}
+
+		// 激活码格式 XXXX-XXXX-XXXX-XXXX-C 转为 hex，为空时签发不绑定机器的密钥
+		fpHex := req.Fingerprint
+		if strings.Contains(fpHex, "-") {
+			_, fpHex, err = hwid.DecodeActivationCodeStrict(fpHex)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to decode fingerprint: " + err.Error()})
+				return
+			}
+		}
+
+		// 短密钥精确到天，有效期算到最后一天结束
+		k := verify.ShortKey{
+			ProductID: product.ID,
+			Expiry:    time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, req.ValidityDays+1),
+			Features:  req.Features,
+		}
+		code, err := verify.IssueShortKey(product.ShortKeySecret, k, fpHex)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "key": code, "exp": k.Expiry.Unix()})
+	}
+}
@@ -0,0 +1,130 @@
+package license
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/square/go-jose/v3"
+)
+
+// ------------------ Signer ------------------
+
+// Signer 许可证签名器，私钥可以来自本地 PEM 文件，也可以保存在 HSM/令牌中永不离开设备
+// 实现 jose.OpaqueSigner，因此可以直接交给 jose.NewSigner 使用
+type Signer interface {
+	jose.OpaqueSigner
+	// Close 释放签名器持有的资源（如 PKCS#11 会话）
+	Close() error
+}
+
+// 签名器类型
+const (
+	SignerTypeFile   = "file"
+	SignerTypePKCS11 = "pkcs11"
+)
+
+// NewSigner 根据配置创建签名器，signerType 为空时默认使用本地私钥文件
+func NewSigner(signerType, privateKeyPath string, p11 PKCS11Config) (Signer, error) {
+	switch signerType {
+	case "", SignerTypeFile:
+		return NewFileSigner(privateKeyPath)
+	case SignerTypePKCS11:
+		return NewPKCS11Signer(p11)
+	default:
+		return nil, fmt.Errorf("unknown signer type: %s", signerType)
+	}
+}
+
+// cryptoSigner 将 crypto.Signer 适配为 jose.OpaqueSigner，只支持 RSA 密钥
+type cryptoSigner struct {
+	signer crypto.Signer
+	pub    *rsa.PublicKey
+}
+
+func newCryptoSigner(s crypto.Signer) (*cryptoSigner, error) {
+	pub, ok := s.Public().(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA signing key")
+	}
+	return &cryptoSigner{signer: s, pub: pub}, nil
+}
+
+func (s *cryptoSigner) Public() *jose.JSONWebKey {
+	return &jose.JSONWebKey{Key: s.pub, Use: "sig"}
+}
+
+func (s *cryptoSigner) Algs() []jose.SignatureAlgorithm {
+	return []jose.SignatureAlgorithm{jose.RS256, jose.PS256}
+}
+
+func (s *cryptoSigner) SignPayload(payload []byte, alg jose.SignatureAlgorithm) ([]byte, error) {
+	digest := sha256.Sum256(payload)
+	switch alg {
+	case jose.RS256:
+		return s.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case jose.PS256:
+		return s.signer.Sign(rand.Reader, digest[:], &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       crypto.SHA256,
+		})
+	default:
+		return nil, jose.ErrUnsupportedAlgorithm
+	}
+}
+
+// ------------------ 本地文件签名器 ------------------
+
+// FileSigner 使用本地 PEM 私钥签名
+type FileSigner struct {
+	*cryptoSigner
+}
+
+// NewFileSigner 加载 PKCS1 或 PKCS8 格式的 RSA 私钥
+func NewFileSigner(privateKeyPath string) (*FileSigner, error) {
+	privKey, err := loadPrivateKey(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := newCryptoSigner(privKey)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSigner{cryptoSigner: cs}, nil
+}
+
+// Close 本地私钥无需释放资源
+func (s *FileSigner) Close() error {
+	return nil
+}
+
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("invalid private key pem")
+	}
+	privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		// 尝试使用PKCS8格式解析
+		privKeyInterface, err2 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err2 != nil {
+			return nil, fmt.Errorf("failed to parse private key: %v", err)
+		}
+		var ok bool
+		privKey, ok = privKeyInterface.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("not an RSA private key")
+		}
+	}
+	return privKey, nil
+}
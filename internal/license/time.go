@@ -0,0 +1,28 @@
+package license
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ------------------ Trusted Time Handler ------------------
+
+// TimeHandler 返回签名的当前时间，客户端传入随机 nonce 防止重放
+func TimeHandler(issuer *Issuer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nonce := c.Query("nonce")
+		if len(nonce) > 128 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nonce too long"})
+			return
+		}
+		now := time.Now().UTC()
+		token, err := issuer.SignTimestamp(now, nonce)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token, "time": now.Unix()})
+	}
+}
@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"license/verify"
+)
+
+// ------------------ Cached Checker ------------------
+
+// CacheConfig 缓存校验结果的配置
+type CacheConfig struct {
+	Verifier *verify.Verifier
+	Source   LicenseSource
+	// Interval 完整重新校验（签名、本机指纹、虚拟机策略）的间隔，默认 10 分钟
+	Interval time.Duration
+	// RetryInterval 校验失败后重新校验的间隔，默认 30 秒，不超过 Interval；
+	// 数据库或远程来源的临时故障不会让服务拒绝请求长达一个 Interval
+	RetryInterval time.Duration
+	// WatchInterval 检查许可证来源是否变化的间隔，默认 5 秒，为负数时不检查；
+	// 来源实现 Versioner 时比较版本标识，否则比较内容摘要
+	WatchInterval time.Duration
+	// Clock 当前时间，默认 time.Now，应与 Verifier 使用同一个时钟
+	Clock func() time.Time
+	// Observers 接收许可证到期、吊销、续期等事件
+	Observers []Observer
+	// WarnBefore 到期前多久发送 EventExpiring，默认 DefaultWarnBefore
+	WarnBefore []time.Duration
+}
+
+// CachedChecker 缓存校验结果的 Checker：许可证只在来源变化、到达重新校验间隔、
+// 到期或宽限期结束时重新校验，其余请求只读取缓存
+type CachedChecker struct {
+	cfg   CacheConfig
+	state atomic.Pointer[cacheState]
+	mu    sync.Mutex // 同一时间只有一个请求重新校验
+	stop  chan struct{}
+	once  sync.Once
+	// warned 当前许可证已经通知过的最短提前时长，受 mu 保护
+	warned time.Duration
+}
+
+// cacheState 一次校验的结果，until 之前并且距离校验不超过 ttl 时直接返回；
+// ttl 按单调时钟计算，系统时间回拨不会延长缓存
+type cacheState struct {
+	license   *verify.License
+	err       error
+	stamp     string
+	until     time.Time
+	ttl       time.Duration
+	checkedAt time.Time // 带单调时钟读数
+}
+
+func (s *cacheState) fresh(now time.Time) bool {
+	return now.Before(s.until) && time.Since(s.checkedAt) < s.ttl
+}
+
+// NewCachedChecker 创建 CachedChecker，立即校验一次，WatchInterval 大于 0 时在后台检查来源变化
+func NewCachedChecker(cfg CacheConfig) (*CachedChecker, error) {
+	if cfg.Verifier == nil || cfg.Source == nil {
+		return nil, errors.New("cached checker requires a verifier and a license source")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Minute
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = 30 * time.Second
+	}
+	if cfg.RetryInterval > cfg.Interval {
+		cfg.RetryInterval = cfg.Interval
+	}
+	if cfg.WatchInterval == 0 {
+		cfg.WatchInterval = 5 * time.Second
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = time.Now
+	}
+	if cfg.WarnBefore == nil {
+		cfg.WarnBefore = DefaultWarnBefore
+	}
+	c := &CachedChecker{cfg: cfg, stop: make(chan struct{})}
+	c.Refresh(context.Background())
+	if cfg.WatchInterval > 0 {
+		go c.watch()
+	}
+	return c, nil
+}
+
+// Check 返回缓存的校验结果，缓存失效时重新校验
+func (c *CachedChecker) Check(ctx context.Context) (*verify.License, error) {
+	s := c.state.Load()
+	if s.fresh(c.cfg.Clock()) {
+		return s.license, s.err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 等待锁期间其他请求可能已经重新校验
+	if s = c.state.Load(); s.fresh(c.cfg.Clock()) {
+		return s.license, s.err
+	}
+	s = c.refresh(ctx)
+	return s.license, s.err
+}
+
+// Refresh 立即重新校验，例如激活新许可证之后
+func (c *CachedChecker) Refresh(ctx context.Context) (*verify.License, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.refresh(ctx)
+	return s.license, s.err
+}
+
+// Close 停止后台检查
+func (c *CachedChecker) Close() {
+	c.once.Do(func() { close(c.stop) })
+}
+
+func (c *CachedChecker) refresh(ctx context.Context) *cacheState {
+	// 先取版本标识再读取许可证，读取期间发生的变化会在下次检查时发现
+	prev := c.state.Load()
+	s := &cacheState{stamp: sourceVersion(ctx, c.cfg.Source)}
+	candidates, err := c.cfg.Source.Load(ctx)
+	if err == nil {
+		s.license, err = verifyFirst(c.cfg.Verifier, candidates)
+	}
+	s.err = err
+
+	now := c.cfg.Clock()
+	s.until = now.Add(c.cfg.Interval)
+	if err != nil {
+		s.until = now.Add(c.cfg.RetryInterval)
+	}
+	if l := s.license; l != nil {
+		// 到期时进入宽限期，宽限期结束时失效，都需要重新校验
+		if now.Before(l.ExpiresAt) && l.ExpiresAt.Before(s.until) {
+			s.until = l.ExpiresAt
+		}
+		if l.ValidUntil.Before(s.until) {
+			s.until = l.ValidUntil
+		}
+		// 到达提前通知的时间点时重新校验，及时发送 EventExpiring
+		for _, w := range c.cfg.WarnBefore {
+			if at := l.ExpiresAt.Add(-w); now.Before(at) && at.Before(s.until) {
+				s.until = at
+			}
+		}
+	}
+	s.ttl = s.until.Sub(now)
+	s.checkedAt = time.Now()
+	c.state.Store(s)
+	c.notify(c.transition(prev, s, now))
+	return s
+}
+
+// watch 定期比较许可证来源的版本标识
+func (c *CachedChecker) watch() {
+	ticker := time.NewTicker(c.cfg.WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			ctx := context.Background()
+			stamp := sourceVersion(ctx, c.cfg.Source)
+			if stamp != c.state.Load().stamp {
+				c.Refresh(ctx)
+			}
+		}
+	}
+}
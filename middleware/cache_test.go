@@ -0,0 +1,266 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"license/verify"
+
+	"github.com/square/go-jose/v3"
+)
+
+// 测试共用的签名密钥，生成 RSA 密钥较慢，只生成一次
+var (
+	keyOnce sync.Once
+	testKey *rsa.PrivateKey
+)
+
+func signingKey(t testing.TB) *rsa.PrivateKey {
+	t.Helper()
+	keyOnce.Do(func() {
+		var err error
+		if testKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			panic(err)
+		}
+	})
+	return testKey
+}
+
+// testClock 可以手动拨动的时钟
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Unix(1_700_000_000, 0).UTC()}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// issue 签发不绑定机器的许可证，在 now 之后 valid 到期
+func issue(t testing.TB, id string, now time.Time, valid time.Duration) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: signingKey(t)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(verify.Claims{
+		Sub:      verify.LicenseSubject,
+		Customer: "acme",
+		ID:       id,
+		Iat:      now.Add(-time.Hour).Unix(),
+		Exp:      now.Add(valid).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verify.EncodeLicenseFile(token, "")
+}
+
+// testVerifier 使用 clock、不校验机器绑定的校验器
+func testVerifier(t testing.TB, clock *testClock, cfg verify.VerifierConfig) *verify.Verifier {
+	t.Helper()
+	cfg.PublicKeys = []*rsa.PublicKey{&signingKey(t).PublicKey}
+	cfg.Clock = clock.Now
+	cfg.SkipFingerprint = true
+	v, err := verify.NewVerifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// memorySource 内存中的许可证，记录读取次数，每次修改都改变版本标识
+type memorySource struct {
+	mu      sync.Mutex
+	content string
+	err     error
+	version int
+	loads   int
+}
+
+func (s *memorySource) Load(ctx context.Context) ([]Candidate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loads++
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.content == "" {
+		return nil, nil
+	}
+	return []Candidate{{Content: s.content, Source: "memory"}}, nil
+}
+
+func (s *memorySource) Version(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strconv.Itoa(s.version), nil
+}
+
+func (s *memorySource) set(content string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.content, s.err = content, err
+	s.version++
+}
+
+func (s *memorySource) loadCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loads
+}
+
+// newCachedChecker 不在后台检查来源变化的 CachedChecker
+func newCachedChecker(t testing.TB, clock *testClock, v *verify.Verifier, src *memorySource, cfg CacheConfig) *CachedChecker {
+	t.Helper()
+	cfg.Verifier, cfg.Source, cfg.Clock = v, src, clock.Now
+	if cfg.WatchInterval == 0 {
+		cfg.WatchInterval = -1
+	}
+	c, err := NewCachedChecker(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestCachedCheckerInterval(t *testing.T) {
+	clock := newTestClock()
+	src := &memorySource{content: issue(t, "lic-1", clock.Now(), 30*24*time.Hour)}
+	c := newCachedChecker(t, clock, testVerifier(t, clock, verify.VerifierConfig{}), src, CacheConfig{Interval: time.Hour})
+
+	for i := 0; i < 5; i++ {
+		l, err := c.Check(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.ID != "lic-1" || l.Source != "memory" {
+			t.Fatalf("unexpected license %+v", l)
+		}
+	}
+	if n := src.loadCount(); n != 1 {
+		t.Fatalf("source loaded %d times, want 1", n)
+	}
+
+	clock.Add(time.Hour)
+	if _, err := c.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := src.loadCount(); n != 2 {
+		t.Fatalf("source loaded %d times after the interval, want 2", n)
+	}
+}
+
+func TestCachedCheckerExpiry(t *testing.T) {
+	clock := newTestClock()
+	src := &memorySource{content: issue(t, "lic-1", clock.Now(), time.Hour)}
+	v := testVerifier(t, clock, verify.VerifierConfig{Grace: time.Hour})
+	c := newCachedChecker(t, clock, v, src, CacheConfig{Interval: 24 * time.Hour})
+
+	// 到期和宽限期结束时都重新校验，不等待重新校验间隔
+	clock.Add(time.Hour + time.Second)
+	l, err := c.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.InGrace {
+		t.Fatal("license should be in grace period")
+	}
+	clock.Add(time.Hour)
+	if _, err := c.Check(context.Background()); !errors.Is(err, verify.ErrExpired) {
+		t.Fatalf("Check() error = %v, want %v", err, verify.ErrExpired)
+	}
+}
+
+func TestCachedCheckerRetry(t *testing.T) {
+	clock := newTestClock()
+	src := &memorySource{err: errors.New("database is locked")}
+	c := newCachedChecker(t, clock, testVerifier(t, clock, verify.VerifierConfig{}), src,
+		CacheConfig{Interval: time.Hour, RetryInterval: time.Minute})
+	if _, err := c.Check(context.Background()); err == nil {
+		t.Fatal("Check() should fail while the source is unavailable")
+	}
+
+	// 来源恢复后不需要等待完整的重新校验间隔
+	src.set(issue(t, "lic-1", clock.Now(), 30*24*time.Hour), nil)
+	clock.Add(time.Minute)
+	if _, err := c.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCachedCheckerRefresh(t *testing.T) {
+	clock := newTestClock()
+	src := &memorySource{}
+	c := newCachedChecker(t, clock, testVerifier(t, clock, verify.VerifierConfig{}), src, CacheConfig{Interval: time.Hour})
+	if _, err := c.Check(context.Background()); !errors.Is(err, ErrNoLicense) {
+		t.Fatalf("Check() error = %v, want %v", err, ErrNoLicense)
+	}
+
+	// 激活新许可证后立即生效
+	src.set(issue(t, "lic-1", clock.Now(), 30*24*time.Hour), nil)
+	if _, err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCachedCheckerWatch(t *testing.T) {
+	clock := newTestClock()
+	src := &memorySource{content: issue(t, "lic-1", clock.Now(), 30*24*time.Hour)}
+	c := newCachedChecker(t, clock, testVerifier(t, clock, verify.VerifierConfig{}), src,
+		CacheConfig{Interval: time.Hour, WatchInterval: 5 * time.Millisecond})
+
+	src.set(issue(t, "lic-2", clock.Now(), 30*24*time.Hour), nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l, err := c.Check(context.Background())
+		if err == nil && l.ID == "lic-2" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("license change was not picked up: %+v, %v", l, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNewCachedCheckerConfig(t *testing.T) {
+	clock := newTestClock()
+	if _, err := NewCachedChecker(CacheConfig{Source: &memorySource{}}); err == nil {
+		t.Fatal("NewCachedChecker() without a verifier should fail")
+	}
+	if _, err := NewCachedChecker(CacheConfig{Verifier: testVerifier(t, clock, verify.VerifierConfig{})}); err == nil {
+		t.Fatal("NewCachedChecker() without a source should fail")
+	}
+}
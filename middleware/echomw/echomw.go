@@ -0,0 +1,43 @@
+// Package echomw 许可证中间件的 echo 适配器
+package echomw
+
+import (
+	"license/middleware"
+	"license/verify"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ContextKey echo 上下文中保存许可证的键
+const ContextKey = "license"
+
+// License 取出中间件校验通过的许可证
+func License(c echo.Context) (*verify.License, bool) {
+	l, ok := c.Get(ContextKey).(*verify.License)
+	return l, ok
+}
+
+// New 返回 echo 中间件，校验通过的许可证同时放入 echo 上下文和请求 context
+func New(m *middleware.Middleware) echo.MiddlewareFunc {
+	status := m.StatusHandler()
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if m.IsStatusRequest(req.Method, req.URL.Path) {
+				status.ServeHTTP(c.Response(), req)
+				return nil
+			}
+			if m.Allowed(req.Method, req.URL.Path) {
+				return next(c)
+			}
+			l, err := m.Check(req.Context())
+			if err != nil {
+				m.Deny(c.Response(), req, err)
+				return nil
+			}
+			c.SetRequest(req.WithContext(middleware.NewContext(req.Context(), l)))
+			c.Set(ContextKey, l)
+			return next(c)
+		}
+	}
+}
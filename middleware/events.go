@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"license/verify"
+)
+
+// ------------------ Events ------------------
+
+// EventType 许可证状态变化的类型
+type EventType string
+
+const (
+	// EventExpiring 距离到期不超过 WarnBefore 中的某个时长，每个时长只通知一次
+	EventExpiring EventType = "expiring"
+	// EventGrace 许可证已到期，进入宽限期
+	EventGrace EventType = "grace"
+	// EventExpired 许可证已过期（超过宽限期）
+	EventExpired EventType = "expired"
+	// EventRevoked 许可证已被吊销
+	EventRevoked EventType = "revoked"
+	// EventRenewed 换成了新的许可证，或者许可证失效后重新有效
+	EventRenewed EventType = "renewed"
+	// EventInvalid 许可证因为其他原因无效，例如没有许可证或指纹不匹配
+	EventInvalid EventType = "invalid"
+)
+
+// Event 许可证状态变化
+type Event struct {
+	Type EventType
+	Time time.Time
+	// License 当前有效的许可证，许可证无效时为空
+	License *verify.License
+	// Previous 变化前的许可证
+	Previous *verify.License
+	// Err 许可证无效的原因
+	Err error
+	// Remaining 距离到期的时间，EventExpiring 时有效
+	Remaining time.Duration
+}
+
+// Observer 接收许可证事件，在重新校验的 goroutine 中同步调用，应尽快返回
+type Observer interface {
+	OnLicenseEvent(e Event)
+}
+
+// ObserverFunc 把函数包装为 Observer
+type ObserverFunc func(e Event)
+
+func (f ObserverFunc) OnLicenseEvent(e Event) { f(e) }
+
+// DefaultWarnBefore 默认在到期前 30 天、7 天和 1 天通知
+var DefaultWarnBefore = []time.Duration{30 * 24 * time.Hour, 7 * 24 * time.Hour, 24 * time.Hour}
+
+// errorEvent 许可证无效时的事件类型
+func errorEvent(err error) EventType {
+	switch {
+	case errors.Is(err, verify.ErrRevoked):
+		return EventRevoked
+	case errors.Is(err, verify.ErrExpired):
+		return EventExpired
+	}
+	return EventInvalid
+}
+
+// transition 比较前后两次校验结果得到事件，warned 为当前许可证已经通知过的最短提前时长
+func (c *CachedChecker) transition(prev, cur *cacheState, now time.Time) []Event {
+	var prevLicense *verify.License
+	if prev != nil {
+		prevLicense = prev.license
+	}
+	l := cur.license
+	if l == nil {
+		t := errorEvent(cur.err)
+		// 同一类错误只通知一次
+		if prev != nil && prev.license == nil && errorEvent(prev.err) == t {
+			return nil
+		}
+		return []Event{{Type: t, Time: now, Previous: prevLicense, Err: cur.err}}
+	}
+
+	var events []Event
+	renewed := prev != nil && (prevLicense == nil ||
+		l.ID != prevLicense.ID || l.ExpiresAt.After(prevLicense.ExpiresAt))
+	if renewed {
+		c.warned = 0
+		events = append(events, Event{Type: EventRenewed, Time: now, License: l, Previous: prevLicense})
+	}
+	if l.InGrace {
+		if prevLicense == nil || !prevLicense.InGrace || renewed {
+			events = append(events, Event{Type: EventGrace, Time: now, License: l, Previous: prevLicense})
+		}
+		return events
+	}
+	remaining := l.ExpiresAt.Sub(now)
+	threshold := time.Duration(0)
+	for _, w := range c.cfg.WarnBefore {
+		if remaining <= w && (threshold == 0 || w < threshold) {
+			threshold = w
+		}
+	}
+	if threshold > 0 && (c.warned == 0 || threshold < c.warned) {
+		c.warned = threshold
+		events = append(events, Event{Type: EventExpiring, Time: now, License: l, Previous: prevLicense, Remaining: remaining})
+	}
+	return events
+}
+
+func (c *CachedChecker) notify(events []Event) {
+	for _, e := range events {
+		for _, o := range c.cfg.Observers {
+			o.OnLicenseEvent(e)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"license/verify"
+)
+
+// eventRecorder 记录收到的事件
+type eventRecorder struct {
+	events []Event
+}
+
+func (r *eventRecorder) OnLicenseEvent(e Event) { r.events = append(r.events, e) }
+
+// take 返回并清空记录的事件类型
+func (r *eventRecorder) take() []EventType {
+	var types []EventType
+	for _, e := range r.events {
+		types = append(types, e.Type)
+	}
+	r.events = nil
+	return types
+}
+
+func TestCachedCheckerEvents(t *testing.T) {
+	clock := newTestClock()
+	day := 24 * time.Hour
+	revoked := verify.RevokedIDs{}
+	v := testVerifier(t, clock, verify.VerifierConfig{Grace: time.Hour, Revocations: revoked})
+	src := &memorySource{content: issue(t, "lic-1", clock.Now(), 10*day)}
+	rec := &eventRecorder{}
+	c := newCachedChecker(t, clock, v, src, CacheConfig{
+		Interval:   30 * day,
+		Observers:  []Observer{rec},
+		WarnBefore: []time.Duration{7 * day, day},
+	})
+	ctx := context.Background()
+
+	// 时间变化由 Check 发现，许可证变化通过 Refresh 立即生效
+	steps := []struct {
+		name    string
+		prepare func()
+		refresh bool
+		want    []EventType
+	}{
+		{"first check", func() {}, false, nil},
+		{"seven days left", func() { clock.Add(3*day + time.Second) }, false, []EventType{EventExpiring}},
+		{"warned once per threshold", func() { clock.Add(time.Hour) }, false, nil},
+		{"one day left", func() { clock.Add(6 * day) }, false, []EventType{EventExpiring}},
+		{"renewed", func() { src.set(issue(t, "lic-2", clock.Now(), 30*day), nil) }, true, []EventType{EventRenewed}},
+		{"revoked", func() { revoked["lic-2"] = true }, true, []EventType{EventRevoked}},
+		{"revoked again", func() {}, true, nil},
+		{
+			"valid again and about to expire",
+			func() { src.set(issue(t, "lic-3", clock.Now(), time.Hour), nil) },
+			true,
+			[]EventType{EventRenewed, EventExpiring},
+		},
+		{"grace", func() { clock.Add(time.Hour + time.Second) }, false, []EventType{EventGrace}},
+		{"expired", func() { clock.Add(time.Hour) }, false, []EventType{EventExpired}},
+		{"removed", func() { src.set("", nil) }, true, []EventType{EventInvalid}},
+	}
+	for _, step := range steps {
+		step.prepare()
+		if step.refresh {
+			c.Refresh(ctx)
+		} else {
+			c.Check(ctx)
+		}
+		if got := rec.take(); !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s: events = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestExpiringEvent(t *testing.T) {
+	clock := newTestClock()
+	rec := &eventRecorder{}
+	src := &memorySource{content: issue(t, "lic-1", clock.Now(), 12*time.Hour)}
+	newCachedChecker(t, clock, testVerifier(t, clock, verify.VerifierConfig{}), src, CacheConfig{Observers: []Observer{rec}})
+
+	// 第一次校验时已经临近到期，使用默认的提前通知时长
+	if len(rec.events) != 1 {
+		t.Fatalf("events = %v, want one expiring event", rec.events)
+	}
+	e := rec.events[0]
+	if e.Type != EventExpiring || e.Remaining != 12*time.Hour || e.License == nil || e.License.ID != "lic-1" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+}
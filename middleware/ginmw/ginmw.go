@@ -0,0 +1,53 @@
+// Package ginmw 许可证中间件的 gin 适配器
+package ginmw
+
+import (
+	"license/middleware"
+	"license/verify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContextKey gin 上下文中保存许可证的键
+const ContextKey = "license"
+
+// License 取出中间件校验通过的许可证
+func License(c *gin.Context) (*verify.License, bool) {
+	v, ok := c.Get(ContextKey)
+	if !ok {
+		return nil, false
+	}
+	l, ok := v.(*verify.License)
+	return l, ok
+}
+
+// Status 许可证状态接口，可以挂载到任意路由，例如 r.GET("/license/status", ginmw.Status(m))
+func Status(m *middleware.Middleware) gin.HandlerFunc {
+	return gin.WrapH(m.StatusHandler())
+}
+
+// New 返回 gin 中间件，校验通过的许可证同时放入 gin 上下文和请求 context
+func New(m *middleware.Middleware) gin.HandlerFunc {
+	status := m.StatusHandler()
+	return func(c *gin.Context) {
+		if m.IsStatusRequest(c.Request.Method, c.Request.URL.Path) {
+			status.ServeHTTP(c.Writer, c.Request)
+			c.Abort()
+			return
+		}
+		if m.Allowed(c.Request.Method, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		l, err := m.Check(c.Request.Context())
+		if err != nil {
+			m.Deny(c.Writer, c.Request, err)
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(middleware.NewContext(c.Request.Context(), l))
+		c.Set(ContextKey, l)
+		c.Set("license.customer", l.Customer)
+		c.Next()
+	}
+}
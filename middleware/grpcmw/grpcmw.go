@@ -0,0 +1,76 @@
+// Package grpcmw 许可证检查的 gRPC 拦截器
+package grpcmw
+
+import (
+	"context"
+
+	"license/middleware"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DenyFunc 把许可证错误转换为返回给客户端的 gRPC 错误
+type DenyFunc func(ctx context.Context, fullMethod string, err error) error
+
+// DefaultDeny 返回 PermissionDenied
+func DefaultDeny(ctx context.Context, fullMethod string, err error) error {
+	return status.Error(codes.PermissionDenied, middleware.ErrorMessage(err))
+}
+
+// Interceptors gRPC 拦截器，放行规则按完整方法名匹配，例如 /grpc.health.v1.Health/**，
+// 方法固定为 POST
+type Interceptors struct {
+	m    *middleware.Middleware
+	deny DenyFunc
+}
+
+// New 创建拦截器，deny 为空时使用 DefaultDeny
+func New(m *middleware.Middleware, deny DenyFunc) *Interceptors {
+	if deny == nil {
+		deny = DefaultDeny
+	}
+	return &Interceptors{m: m, deny: deny}
+}
+
+func (i *Interceptors) check(ctx context.Context, fullMethod string) (context.Context, error) {
+	if i.m.Allowed("POST", fullMethod) {
+		return ctx, nil
+	}
+	l, err := i.m.Check(ctx)
+	if err != nil {
+		return nil, i.deny(ctx, fullMethod, err)
+	}
+	return middleware.NewContext(ctx, l), nil
+}
+
+// Unary 一元调用拦截器
+func (i *Interceptors) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := i.check(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream 流式调用拦截器
+func (i *Interceptors) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := i.check(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// serverStream 替换流的 context，处理函数可以用 middleware.FromContext 取出许可证
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
@@ -0,0 +1,196 @@
+// Package middleware 与框架无关的许可证中间件：net/http（可直接用于 chi），
+// gin、echo 和 gRPC 的适配器分别在 ginmw、echomw、grpcmw 子包中，只有引用时才依赖对应框架
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"license/verify"
+)
+
+// ------------------ Checker ------------------
+
+// Checker 返回当前有效的许可证，许可证无效时返回错误
+type Checker interface {
+	Check(ctx context.Context) (*verify.License, error)
+}
+
+// CheckerFunc 把函数包装为 Checker
+type CheckerFunc func(ctx context.Context) (*verify.License, error)
+
+func (f CheckerFunc) Check(ctx context.Context) (*verify.License, error) { return f(ctx) }
+
+// VerifyChecker 每次检查时从来源读取许可证并用校验器校验，不缓存结果
+func VerifyChecker(v *verify.Verifier, source LicenseSource) Checker {
+	return CheckerFunc(func(ctx context.Context) (*verify.License, error) {
+		candidates, err := source.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return verifyFirst(v, candidates)
+	})
+}
+
+// verifyFirst 返回第一个校验通过的许可证，都不通过时返回优先级最高的许可证的错误
+func verifyFirst(v *verify.Verifier, candidates []Candidate) (*verify.License, error) {
+	if len(candidates) == 0 {
+		return nil, ErrNoLicense
+	}
+	var firstErr error
+	for _, c := range candidates {
+		l, err := v.Verify(c.Content)
+		if err == nil {
+			l.Source = c.Source
+			return l, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// ------------------ Allow List ------------------
+
+// Rule 放行规则：Pattern 按 path.Match 匹配请求路径，以 /** 结尾时匹配该前缀下的所有路径；
+// Methods 为空表示所有方法
+type Rule struct {
+	Methods []string `json:"methods"`
+	Pattern string   `json:"path"`
+}
+
+// Match 请求方法和路径是否命中规则
+func (r Rule) Match(method, p string) bool {
+	if len(r.Methods) > 0 {
+		ok := false
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, method) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if prefix, ok := strings.CutSuffix(r.Pattern, "/**"); ok {
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+	matched, err := path.Match(r.Pattern, p)
+	return err == nil && matched
+}
+
+// ------------------ Middleware ------------------
+
+// DenyFunc 渲染许可证检查失败的响应
+type DenyFunc func(w http.ResponseWriter, r *http.Request, err error)
+
+// Config 中间件配置
+type Config struct {
+	Checker Checker
+	// Allow 不检查许可证的请求，例如激活接口和指纹查询接口
+	Allow []Rule
+	// Deny 渲染拒绝响应，默认返回 403 和 {"error": ...}
+	Deny DenyFunc
+	// StatusPath 非空时在该路径挂载状态接口（GET），例如 /license/status
+	StatusPath string
+}
+
+// Middleware 许可证中间件，各框架适配器共用
+type Middleware struct {
+	checker Checker
+	allow   []Rule
+	deny    DenyFunc
+	// statusPath 状态接口的路径
+	statusPath string
+}
+
+// New 创建中间件
+func New(cfg Config) (*Middleware, error) {
+	if cfg.Checker == nil {
+		return nil, errors.New("license middleware requires a checker")
+	}
+	m := &Middleware{checker: cfg.Checker, allow: cfg.Allow, deny: cfg.Deny, statusPath: cfg.StatusPath}
+	if m.deny == nil {
+		m.deny = DefaultDeny
+	}
+	return m, nil
+}
+
+// Allowed 请求是否命中放行规则
+func (m *Middleware) Allowed(method, p string) bool {
+	for _, r := range m.allow {
+		if r.Match(method, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check 检查许可证
+func (m *Middleware) Check(ctx context.Context) (*verify.License, error) {
+	return m.checker.Check(ctx)
+}
+
+// Deny 渲染拒绝响应
+func (m *Middleware) Deny(w http.ResponseWriter, r *http.Request, err error) {
+	m.deny(w, r, err)
+}
+
+// Handler net/http 中间件，签名与 chi 的中间件相同，可以直接 r.Use(m.Handler)
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	status := m.StatusHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.IsStatusRequest(r.Method, r.URL.Path) {
+			status.ServeHTTP(w, r)
+			return
+		}
+		if m.Allowed(r.Method, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		l, err := m.Check(r.Context())
+		if err != nil {
+			m.Deny(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), l)))
+	})
+}
+
+// DefaultDeny 返回 403 和 {"error": ...}，签名或格式错误时加上 invalid license 前缀，
+// 没有许可证时提示激活
+func DefaultDeny(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]string{"error": ErrorMessage(err)})
+}
+
+// ErrorMessage 拒绝响应中的错误信息，与旧的 gin 中间件一致
+func ErrorMessage(err error) string {
+	if errors.Is(err, ErrNoLicense) || errors.Is(err, verify.ErrFingerprintMismatch) ||
+		errors.Is(err, verify.ErrVMNotAllowed) || errors.Is(err, verify.ErrClockTampered) {
+		return err.Error()
+	}
+	return "invalid license: " + err.Error()
+}
+
+// ------------------ Context ------------------
+
+type contextKey struct{}
+
+// NewContext 把校验通过的许可证放入 context
+func NewContext(ctx context.Context, l *verify.License) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext 取出中间件校验通过的许可证
+func FromContext(ctx context.Context) (*verify.License, bool) {
+	l, ok := ctx.Value(contextKey{}).(*verify.License)
+	return l, ok
+}
@@ -0,0 +1,342 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------------ License Source ------------------
+
+// ErrNoLicense 所有来源中都没有许可证
+var ErrNoLicense = errors.New("no license, please activate")
+
+// Candidate 候选许可证
+type Candidate struct {
+	Content string
+	// Source 来源描述，校验通过后写入 License.Source，例如 file:/etc/app/license.lic
+	Source string
+}
+
+// LicenseSource 许可证来源，Load 按优先级返回候选许可证，没有许可证时返回空列表，
+// 校验时使用第一个校验通过的许可证
+type LicenseSource interface {
+	Load(ctx context.Context) ([]Candidate, error)
+}
+
+// Versioner 可以廉价判断内容是否变化的来源，例如比较文件修改时间，
+// 不实现时 CachedChecker 比较 Load 内容的摘要
+type Versioner interface {
+	Version(ctx context.Context) (string, error)
+}
+
+// sourceVersion 来源的版本标识
+func sourceVersion(ctx context.Context, s LicenseSource) string {
+	if v, ok := s.(Versioner); ok {
+		stamp, err := v.Version(ctx)
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return stamp
+	}
+	stamp, err := contentVersion(ctx, s)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return stamp
+}
+
+// contentVersion 读取全部候选许可证并计算摘要，来源无法廉价判断变化时使用
+func contentVersion(ctx context.Context, s LicenseSource) (string, error) {
+	candidates, err := s.Load(ctx)
+	if err != nil {
+		return "", err
+	}
+	return digest(candidates), nil
+}
+
+func digest(candidates []Candidate) string {
+	h := sha256.New()
+	for _, c := range candidates {
+		h.Write([]byte(c.Content))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ------------------ Chain ------------------
+
+// Chain 按优先级组合多个来源，依次返回各来源的候选许可证；
+// 某个来源出错时继续读取其他来源，全部没有许可证时才返回错误
+type Chain []LicenseSource
+
+func (c Chain) Load(ctx context.Context) ([]Candidate, error) {
+	var all []Candidate
+	var firstErr error
+	for _, s := range c {
+		candidates, err := s.Load(ctx)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		all = append(all, candidates...)
+	}
+	if len(all) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return all, nil
+}
+
+func (c Chain) Version(ctx context.Context) (string, error) {
+	stamps := make([]string, len(c))
+	for i, s := range c {
+		stamps[i] = sourceVersion(ctx, s)
+	}
+	return strings.Join(stamps, "|"), nil
+}
+
+// ------------------ File ------------------
+
+// FileSource 单个许可证文件，文件不存在表示没有许可证
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) Load(ctx context.Context) ([]Candidate, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []Candidate{{Content: string(b), Source: "file:" + s.Path}}, nil
+}
+
+func (s FileSource) Version(ctx context.Context) (string, error) {
+	return fileVersion(s.Path)
+}
+
+func fileVersion(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%d", path, fi.ModTime().UnixNano(), fi.Size()), nil
+}
+
+// DirSource 目录中的许可证文件，最近修改的文件优先，Pattern 默认 *.lic
+type DirSource struct {
+	Dir     string
+	Pattern string
+}
+
+func (s DirSource) files() ([]string, error) {
+	pattern := s.Pattern
+	if pattern == "" {
+		pattern = "*.lic"
+	}
+	paths, err := filepath.Glob(filepath.Join(s.Dir, pattern))
+	if err != nil {
+		return nil, err
+	}
+	modTimes := make(map[string]time.Time, len(paths))
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil {
+			modTimes[p] = fi.ModTime()
+		}
+	}
+	sort.SliceStable(paths, func(i, j int) bool { return modTimes[paths[i]].After(modTimes[paths[j]]) })
+	return paths, nil
+}
+
+func (s DirSource) Load(ctx context.Context) ([]Candidate, error) {
+	paths, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	var out []Candidate
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		out = append(out, Candidate{Content: string(b), Source: "file:" + p})
+	}
+	return out, nil
+}
+
+func (s DirSource) Version(ctx context.Context) (string, error) {
+	paths, err := s.files()
+	if err != nil {
+		return "", err
+	}
+	stamps := make([]string, 0, len(paths))
+	for _, p := range paths {
+		v, err := fileVersion(p)
+		if err != nil {
+			return "", err
+		}
+		stamps = append(stamps, v)
+	}
+	return strings.Join(stamps, "|"), nil
+}
+
+// ------------------ Env / Embedded ------------------
+
+// EnvSource 环境变量中的许可证文件内容
+type EnvSource struct {
+	Name string
+}
+
+func (s EnvSource) Load(ctx context.Context) ([]Candidate, error) {
+	if v := os.Getenv(s.Name); strings.TrimSpace(v) != "" {
+		return []Candidate{{Content: v, Source: "env:" + s.Name}}, nil
+	}
+	return nil, nil
+}
+
+func (s EnvSource) Version(ctx context.Context) (string, error) {
+	h := sha256.Sum256([]byte(os.Getenv(s.Name)))
+	return hex.EncodeToString(h[:]), nil
+}
+
+// StaticSource 编译进程序的许可证，例如用 go:embed 嵌入的文件
+type StaticSource string
+
+func (s StaticSource) Load(ctx context.Context) ([]Candidate, error) {
+	if strings.TrimSpace(string(s)) == "" {
+		return nil, nil
+	}
+	return []Candidate{{Content: string(s), Source: "embedded"}}, nil
+}
+
+func (s StaticSource) Version(ctx context.Context) (string, error) { return "static", nil }
+
+// ------------------ Database ------------------
+
+// SQLSource 数据库中的许可证，Query 返回一列许可证内容，按优先级排序
+type SQLSource struct {
+	DB    *sql.DB
+	Query string
+	Args  []any
+	// VersionQuery 返回单个值的廉价查询，例如 SELECT max(id) FROM licenses，
+	// 结果变化时才重新执行 Query；为空时每次检查都执行 Query 并比较内容
+	VersionQuery string
+}
+
+func (s SQLSource) Load(ctx context.Context) ([]Candidate, error) {
+	rows, err := s.DB.QueryContext(ctx, s.Query, s.Args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query licenses: %v", err)
+	}
+	defer rows.Close()
+	var out []Candidate
+	for rows.Next() {
+		var lic string
+		if err := rows.Scan(&lic); err != nil {
+			return nil, fmt.Errorf("failed to scan license: %v", err)
+		}
+		out = append(out, Candidate{Content: lic, Source: "database"})
+	}
+	return out, rows.Err()
+}
+
+func (s SQLSource) Version(ctx context.Context) (string, error) {
+	if s.VersionQuery == "" {
+		return contentVersion(ctx, s)
+	}
+	var v sql.NullString
+	if err := s.DB.QueryRowContext(ctx, s.VersionQuery).Scan(&v); err != nil {
+		return "", fmt.Errorf("failed to query license version: %v", err)
+	}
+	return v.String, nil
+}
+
+// ------------------ Remote ------------------
+
+// RemoteSource 从许可证服务器下载许可证，响应体为许可证文件内容，404 和 204 表示没有许可证；
+// 服务器返回 ETag 时之后的请求带 If-None-Match，内容未变化时服务器只需返回 304。
+// RemoteSource 缓存上次的响应，需要以指针使用
+type RemoteSource struct {
+	URL    string
+	Header http.Header
+	Client *http.Client // 为空时使用 10 秒超时的客户端
+
+	mu   sync.Mutex
+	etag string
+	last []Candidate
+}
+
+func (s *RemoteSource) Load(ctx context.Context) ([]Candidate, error) {
+	_, candidates, err := s.fetch(ctx)
+	return candidates, err
+}
+
+// Version 服务器返回 ETag 时使用 ETag，否则比较内容摘要
+func (s *RemoteSource) Version(ctx context.Context) (string, error) {
+	etag, candidates, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	if etag != "" {
+		return etag, nil
+	}
+	return digest(candidates), nil
+}
+
+// fetch 发送条件请求，304 时返回上次的结果
+func (s *RemoteSource) fetch(ctx context.Context) (string, []Candidate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	for k, vs := range s.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch license: %v", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && s.etag != "":
+		return s.etag, s.last, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent:
+		s.etag, s.last = "", nil
+		return "", nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return "", nil, fmt.Errorf("failed to fetch license: %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read license: %v", err)
+	}
+	s.etag = resp.Header.Get("ETag")
+	s.last = []Candidate{{Content: string(b), Source: "remote:" + s.URL}}
+	return s.etag, s.last, nil
+}
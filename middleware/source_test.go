@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"license/verify"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sources 候选许可证的来源描述
+func sources(candidates []Candidate) []string {
+	var out []string
+	for _, c := range candidates {
+		out = append(out, c.Source)
+	}
+	return out
+}
+
+func TestFileSource(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "license.lic")
+	s := FileSource{Path: path}
+	candidates, err := s.Load(ctx)
+	if err != nil || len(candidates) != 0 {
+		t.Fatalf("missing file: Load() = %v, %v", candidates, err)
+	}
+	before, err := s.Version(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("license"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	candidates, err = s.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Candidate{{Content: "license", Source: "file:" + path}}; !reflect.DeepEqual(candidates, want) {
+		t.Fatalf("Load() = %v, want %v", candidates, want)
+	}
+	if after, _ := s.Version(ctx); after == before {
+		t.Fatal("Version() did not change after the file was written")
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"old.lic", "new.lic", "notes.txt"} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	candidates, err := DirSource{Dir: dir}.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 最近修改的文件优先，只读取 *.lic
+	want := []string{"file:" + filepath.Join(dir, "new.lic"), "file:" + filepath.Join(dir, "old.lic")}
+	if got := sources(candidates); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Load() sources = %v, want %v", got, want)
+	}
+	candidates, err = DirSource{Dir: dir, Pattern: "*.txt"}.Load(ctx)
+	if err != nil || len(candidates) != 1 || candidates[0].Content != "notes.txt" {
+		t.Fatalf("Load() with pattern = %v, %v", candidates, err)
+	}
+}
+
+func TestEnvAndStaticSource(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("TEST_LICENSE", "")
+	s := EnvSource{Name: "TEST_LICENSE"}
+	if candidates, err := s.Load(ctx); err != nil || len(candidates) != 0 {
+		t.Fatalf("empty env: Load() = %v, %v", candidates, err)
+	}
+	before, _ := s.Version(ctx)
+	t.Setenv("TEST_LICENSE", "license")
+	candidates, err := s.Load(ctx)
+	if err != nil || len(candidates) != 1 || candidates[0].Source != "env:TEST_LICENSE" {
+		t.Fatalf("Load() = %v, %v", candidates, err)
+	}
+	if after, _ := s.Version(ctx); after == before {
+		t.Fatal("Version() did not change after the env was set")
+	}
+
+	if candidates, _ := StaticSource(" \n").Load(ctx); len(candidates) != 0 {
+		t.Fatalf("blank static source: Load() = %v", candidates)
+	}
+	if candidates, _ := StaticSource("license").Load(ctx); len(candidates) != 1 || candidates[0].Source != "embedded" {
+		t.Fatalf("static source: Load() = %v", candidates)
+	}
+}
+
+// errSource 总是出错的来源
+type errSource struct{ err error }
+
+func (s errSource) Load(ctx context.Context) ([]Candidate, error) { return nil, s.err }
+
+// sourceFunc 把函数包装为不实现 Versioner 的来源
+type sourceFunc func(ctx context.Context) ([]Candidate, error)
+
+func (f sourceFunc) Load(ctx context.Context) ([]Candidate, error) { return f(ctx) }
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+	broken := errSource{errors.New("database is locked")}
+
+	// 某个来源出错时继续读取其他来源
+	candidates, err := Chain{broken, StaticSource("a"), StaticSource("b")}.Load(ctx)
+	if err != nil || len(candidates) != 2 || candidates[0].Content != "a" {
+		t.Fatalf("Load() = %v, %v", candidates, err)
+	}
+	// 全部没有许可证时返回错误
+	if _, err := (Chain{StaticSource(""), broken}).Load(ctx); !errors.Is(err, broken.err) {
+		t.Fatalf("Load() error = %v, want %v", err, broken.err)
+	}
+	if candidates, err := (Chain{StaticSource("")}).Load(ctx); err != nil || len(candidates) != 0 {
+		t.Fatalf("empty chain: Load() = %v, %v", candidates, err)
+	}
+
+	// 不实现 Versioner 的来源按内容摘要比较
+	src := &memorySource{content: "a"}
+	chain := Chain{StaticSource("s"), sourceFunc(src.Load)}
+	before, _ := chain.Version(ctx)
+	src.set("b", nil)
+	if after, _ := chain.Version(ctx); after == before {
+		t.Fatal("Version() did not change after a source changed")
+	}
+}
+
+func TestRemoteSource(t *testing.T) {
+	ctx := context.Background()
+	var status atomic.Int32
+	var notModified atomic.Int32
+	status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		code := int(status.Load())
+		if code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("license"))
+	}))
+	defer srv.Close()
+
+	s := &RemoteSource{URL: srv.URL, Header: http.Header{"Authorization": {"Bearer token"}}}
+	for i := 0; i < 2; i++ {
+		candidates, err := s.Load(ctx)
+		if err != nil || len(candidates) != 1 || candidates[0].Content != "license" {
+			t.Fatalf("Load() = %v, %v", candidates, err)
+		}
+	}
+	if notModified.Load() != 1 {
+		t.Fatal("second request should be answered with 304")
+	}
+	if v, err := s.Version(ctx); err != nil || v != `"v1"` {
+		t.Fatalf("Version() = %q, %v", v, err)
+	}
+
+	status.Store(http.StatusNotFound)
+	if candidates, err := s.Load(ctx); err != nil || len(candidates) != 0 {
+		t.Fatalf("404: Load() = %v, %v", candidates, err)
+	}
+	status.Store(http.StatusInternalServerError)
+	if _, err := s.Load(ctx); err == nil {
+		t.Fatal("Load() should fail on 500")
+	}
+	if _, err := (&RemoteSource{URL: srv.URL}).Load(ctx); err == nil {
+		t.Fatal("Load() should fail without credentials")
+	}
+}
+
+func TestSQLSource(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE licenses (id INTEGER PRIMARY KEY, license TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	s := SQLSource{
+		DB:           db,
+		Query:        `SELECT license FROM licenses ORDER BY id DESC`,
+		VersionQuery: `SELECT max(id) FROM licenses`,
+	}
+	if candidates, err := s.Load(ctx); err != nil || len(candidates) != 0 {
+		t.Fatalf("empty table: Load() = %v, %v", candidates, err)
+	}
+	before, err := s.Version(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO licenses (license) VALUES ('old'), ('new')`); err != nil {
+		t.Fatal(err)
+	}
+	candidates, err := s.Load(ctx)
+	if err != nil || len(candidates) != 2 || candidates[0].Content != "new" || candidates[0].Source != "database" {
+		t.Fatalf("Load() = %v, %v", candidates, err)
+	}
+	if after, _ := s.Version(ctx); after == before {
+		t.Fatal("Version() did not change after a license was inserted")
+	}
+	if _, err := (SQLSource{DB: db, Query: `SELECT license FROM missing`}).Load(ctx); err == nil {
+		t.Fatal("Load() should fail for an invalid query")
+	}
+}
+
+func TestVerifyFirst(t *testing.T) {
+	clock := newTestClock()
+	v := testVerifier(t, clock, verify.VerifierConfig{})
+	expired := issue(t, "expired", clock.Now().Add(-48*time.Hour), time.Hour)
+	valid := issue(t, "valid", clock.Now(), 24*time.Hour)
+
+	// 使用第一个校验通过的许可证，并记录来源
+	l, err := VerifyChecker(v, candidateList{
+		{Content: expired, Source: "first"},
+		{Content: valid, Source: "second"},
+	}).Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.ID != "valid" || l.Source != "second" {
+		t.Fatalf("unexpected license %+v", l)
+	}
+	// 都不通过时返回优先级最高的许可证的错误
+	_, err = VerifyChecker(v, candidateList{
+		{Content: expired, Source: "first"},
+		{Content: "garbage", Source: "second"},
+	}).Check(context.Background())
+	if !errors.Is(err, verify.ErrExpired) {
+		t.Fatalf("Check() error = %v, want %v", err, verify.ErrExpired)
+	}
+	if _, err := VerifyChecker(v, StaticSource("")).Check(context.Background()); !errors.Is(err, ErrNoLicense) {
+		t.Fatalf("Check() error = %v, want %v", err, ErrNoLicense)
+	}
+}
+
+// candidateList 固定的候选许可证列表
+type candidateList []Candidate
+
+func (s candidateList) Load(ctx context.Context) ([]Candidate, error) { return s, nil }
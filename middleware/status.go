@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"license/hwid"
+	"license/verify"
+)
+
+// ------------------ Status ------------------
+
+// Status 当前许可证状态，由状态接口以 JSON 返回
+type Status struct {
+	Valid         bool      `json:"valid"`
+	Error         string    `json:"error,omitempty"`
+	ID            string    `json:"id,omitempty"`
+	Customer      string    `json:"customer,omitempty"`
+	Product       string    `json:"product,omitempty"`
+	Features      []string  `json:"features,omitempty"`
+	IssuedAt      time.Time `json:"issuedAt,omitzero"`
+	ExpiresAt     time.Time `json:"expiresAt,omitzero"`
+	RemainingDays int       `json:"remainingDays"`
+	InGrace       bool      `json:"inGrace"`
+	ValidUntil    time.Time `json:"validUntil,omitzero"`
+	// Binding 许可证绑定的机器：绑定方式、激活码对应的指纹和虚拟机策略
+	Binding *BindingStatus `json:"binding,omitempty"`
+	// Source 许可证的来源，例如 file:/etc/app/license.lic
+	Source string `json:"source,omitempty"`
+}
+
+// BindingStatus 许可证的机器绑定信息
+type BindingStatus struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	VMPolicy    string `json:"vmPolicy,omitempty"`
+}
+
+// NewStatus 由校验结果生成状态，剩余天数向上取整，到期后为 0
+func NewStatus(l *verify.License, err error, now time.Time) Status {
+	if err != nil {
+		return Status{Error: ErrorMessage(err)}
+	}
+	st := Status{
+		Valid:         true,
+		ID:            l.ID,
+		Customer:      l.Customer,
+		Product:       l.Product,
+		Features:      l.Features,
+		IssuedAt:      l.IssuedAt,
+		ExpiresAt:     l.ExpiresAt,
+		RemainingDays: l.RemainingDays(now),
+		InGrace:       l.InGrace,
+		ValidUntil:    l.ValidUntil,
+		Source:        l.Source,
+	}
+	binding := l.Binding
+	if binding == "" {
+		binding = hwid.BindingMachine
+	}
+	st.Binding = &BindingStatus{Type: binding, Fingerprint: l.Fingerprint, VMPolicy: l.VMPolicy}
+	return st
+}
+
+// Status 当前许可证状态，使用 CachedChecker 时不会重新校验
+func (m *Middleware) Status(ctx context.Context) Status {
+	l, err := m.Check(ctx)
+	return NewStatus(l, err, time.Now())
+}
+
+// IsStatusRequest 是否为挂载的状态接口请求，状态接口不检查许可证
+func (m *Middleware) IsStatusRequest(method, p string) bool {
+	return m.statusPath != "" && method == http.MethodGet && p == m.statusPath
+}
+
+// StatusHandler 返回当前许可证状态的 JSON，许可证无效时同样返回 200，由 valid 字段区分
+func (m *Middleware) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(m.Status(r.Context()))
+	})
+}
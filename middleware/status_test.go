@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"license/hwid"
+	"license/verify"
+)
+
+func TestNewStatus(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0).UTC()
+	l := &verify.License{
+		ID:          "lic-1",
+		Customer:    "acme",
+		Fingerprint: "abcd",
+		IssuedAt:    now.Add(-time.Hour),
+		ExpiresAt:   now.Add(36 * time.Hour),
+		ValidUntil:  now.Add(36 * time.Hour),
+		Source:      "file:/etc/app/license.lic",
+	}
+	st := NewStatus(l, nil, now)
+	if !st.Valid || st.ID != "lic-1" || st.RemainingDays != 2 || st.Source != l.Source {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	if st.Binding == nil || st.Binding.Type != hwid.BindingMachine || st.Binding.Fingerprint != "abcd" {
+		t.Fatalf("unexpected binding %+v", st.Binding)
+	}
+
+	st = NewStatus(nil, verify.ErrBadSignature, now)
+	if st.Valid || st.Error != "invalid license: "+verify.ErrBadSignature.Error() || st.Binding != nil {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestHandlerStatus(t *testing.T) {
+	clock := newTestClock()
+	src := &memorySource{}
+	checker := newCachedChecker(t, clock, testVerifier(t, clock, verify.VerifierConfig{}), src, CacheConfig{})
+	m, err := New(Config{
+		Checker:    checker,
+		Allow:      []Rule{{Methods: []string{http.MethodPost}, Pattern: "/api/activate"}},
+		StatusPath: "/license/status",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l, ok := FromContext(r.Context()); ok {
+			w.Write([]byte(l.ID))
+		}
+	}))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	status := func() Status {
+		w := serve(http.MethodGet, "/license/status")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status endpoint returned %d", w.Code)
+		}
+		var st Status
+		if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	// 没有许可证时拒绝请求，状态接口和放行的接口仍然可用
+	if w := serve(http.MethodGet, "/api/data"); w.Code != http.StatusForbidden {
+		t.Fatalf("request without a license returned %d", w.Code)
+	}
+	if w := serve(http.MethodPost, "/api/activate"); w.Code != http.StatusOK {
+		t.Fatalf("allowed request returned %d", w.Code)
+	}
+	if st := status(); st.Valid || st.Error != ErrNoLicense.Error() {
+		t.Fatalf("unexpected status %+v", st)
+	}
+
+	src.set(issue(t, "lic-1", clock.Now(), 24*time.Hour), nil)
+	checker.Refresh(context.Background())
+	if w := serve(http.MethodGet, "/api/data"); w.Code != http.StatusOK || w.Body.String() != "lic-1" {
+		t.Fatalf("licensed request returned %d %q", w.Code, w.Body.String())
+	}
+	if st := status(); !st.Valid || st.ID != "lic-1" || st.Source != "memory" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	if got := ErrorMessage(ErrNoLicense); got != ErrNoLicense.Error() {
+		t.Fatalf("ErrorMessage() = %q", got)
+	}
+	if got := ErrorMessage(verify.ErrExpired); got != "invalid license: license expired" {
+		t.Fatalf("ErrorMessage() = %q", got)
+	}
+}
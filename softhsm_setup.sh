@@ -0,0 +1,39 @@
+#!/bin/bash
+
+# 将签名私钥导入 SoftHSM2 令牌，用于在本地验证 PKCS#11 签名器
+# 依赖：softhsm2、openssl
+
+TOKEN_LABEL="${TOKEN_LABEL:-license}"
+KEY_LABEL="${KEY_LABEL:-license-signing}"
+PIN="${LICENSE_PKCS11_PIN:-1234}"
+SO_PIN="${SO_PIN:-5678}"
+PRIVATE_KEY="private.pem"
+
+if [ ! -f "$PRIVATE_KEY" ]; then
+    echo "私钥不存在：$PRIVATE_KEY，请先执行 key_generate.sh"
+    exit 1
+fi
+
+# 初始化令牌
+if softhsm2-util --show-slots | grep -q "Label:.*$TOKEN_LABEL"; then
+    echo "令牌已存在：$TOKEN_LABEL"
+else
+    if ! softhsm2-util --init-token --free --label "$TOKEN_LABEL" --pin "$PIN" --so-pin "$SO_PIN"; then
+        echo "❌ 初始化令牌失败。"
+        exit 1
+    fi
+fi
+
+# softhsm2-util 只接受 PKCS8 格式
+PKCS8_KEY=$(mktemp)
+trap 'rm -f "$PKCS8_KEY"' EXIT
+openssl pkcs8 -topk8 -nocrypt -in "$PRIVATE_KEY" -out "$PKCS8_KEY"
+
+if softhsm2-util --import "$PKCS8_KEY" --token "$TOKEN_LABEL" --label "$KEY_LABEL" --id 01 --pin "$PIN"; then
+    echo "✅ 私钥已导入令牌 $TOKEN_LABEL（标签 $KEY_LABEL）"
+    echo "在 config.json 中设置 \"signerType\": \"pkcs11\"，并导出 LICENSE_PKCS11_PIN=$PIN 后启动服务"
+    echo "确认无误后即可从服务器上删除 $PRIVATE_KEY"
+else
+    echo "❌ 导入私钥失败。"
+    exit 1
+fi
@@ -0,0 +1,237 @@
+package verify
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ------------------ 时钟回拨检测 ------------------
+
+// ErrClockTampered 系统时间被回拨，或者时间记录被篡改
+var ErrClockTampered = errors.New("system clock has been turned back")
+
+// ClockGuardConfig 时钟回拨检测配置
+type ClockGuardConfig struct {
+	// Path 持久化最近一次看到的时间（高水位）的文件
+	Path string
+	// BackupPath 时间记录的第二份副本，最好放在另一个目录，为空不保存副本
+	BackupPath string
+	// Key 时间记录的 HMAC 密钥，应由构建时嵌入或服务端配置的产品密钥经 DeriveClockKey 派生；
+	// 为空时使用 KeyPath 中的安装密钥
+	Key []byte
+	// KeyPath 本机安装密钥文件，首次使用时随机生成，默认 Path + ".key"；
+	// 密钥文件存在而时间记录缺失时说明记录被删除，判定为篡改。
+	// 安装密钥和时间记录放在一起，能回拨时间的人同样能读取密钥伪造记录，只能发现误删和手工修改
+	KeyPath string
+	// Tolerance 允许的回拨幅度，默认 15 分钟，用于容忍 NTP 校时
+	Tolerance time.Duration
+	// Resolution 高水位前进超过该时长才写文件，默认 1 分钟
+	Resolution time.Duration
+}
+
+// DeriveClockKey 由产品密钥派生时间记录的 HMAC 密钥，同一个密钥用于不同用途时互不影响
+func DeriveClockKey(secret []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte("license clock state"))
+	return m.Sum(nil)
+}
+
+// ClockGuard 记录看到过的最晚时间，当前时间早于该时间超过容忍范围时判定为时钟回拨
+type ClockGuard struct {
+	cfg  ClockGuardConfig
+	mu   sync.Mutex
+	mark time.Time
+}
+
+// clockState 持久化的时间记录
+type clockState struct {
+	T   int64  `json:"t"` // Unix 纳秒
+	MAC string `json:"mac"`
+}
+
+// NewClockGuard 创建时钟回拨检测，读取已有的时间记录；
+// 记录校验失败，或者之前运行过而记录被删除时返回 ErrClockTampered
+func NewClockGuard(cfg ClockGuardConfig) (*ClockGuard, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("clock guard requires a state path")
+	}
+	if cfg.Tolerance <= 0 {
+		cfg.Tolerance = 15 * time.Minute
+	}
+	if cfg.Resolution <= 0 {
+		cfg.Resolution = time.Minute
+	}
+	// 调用方提供密钥时无法判断之前是否运行过，只能依靠副本
+	installed := false
+	if len(cfg.Key) == 0 {
+		if cfg.KeyPath == "" {
+			cfg.KeyPath = cfg.Path + ".key"
+		}
+		key, existed, err := loadInstallKey(cfg.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Key, installed = key, existed
+	}
+	g := &ClockGuard{cfg: cfg}
+	mark, found, err := g.load()
+	if err != nil {
+		return nil, err
+	}
+	if found == 0 && installed {
+		return nil, fmt.Errorf("%w: clock state is missing", ErrClockTampered)
+	}
+	g.mark = mark
+	// 首次运行立即写入记录，缺失的副本按另一份恢复
+	if found < g.copies() {
+		if g.mark.IsZero() {
+			g.mark = time.Now()
+		}
+		if err := g.save(g.mark); err != nil {
+			return nil, fmt.Errorf("failed to write clock state: %v", err)
+		}
+	}
+	return g, nil
+}
+
+// loadInstallKey 读取本机安装密钥，不存在时随机生成；existed 表示密钥文件之前已经存在
+func loadInstallKey(path string) (key []byte, existed bool, err error) {
+	b, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(string(b))
+		if err != nil || len(key) != 32 {
+			return nil, true, fmt.Errorf("%w: invalid clock key", ErrClockTampered)
+		}
+		return key, true, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, fmt.Errorf("failed to read clock key: %v", err)
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, false, err
+	}
+	if err := writeFileAtomic(path, []byte(hex.EncodeToString(key))); err != nil {
+		return nil, false, fmt.Errorf("failed to write clock key: %v", err)
+	}
+	return key, false, nil
+}
+
+// Tolerance 允许的回拨幅度
+func (g *ClockGuard) Tolerance() time.Duration { return g.cfg.Tolerance }
+
+// Observe 检查当前时间没有早于高水位超过容忍范围，并推进高水位；
+// 写文件失败不影响校验，下次仍以内存中的高水位为准
+func (g *ClockGuard) Observe(now time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Before(g.mark.Add(-g.cfg.Tolerance)) {
+		return fmt.Errorf("%w: now %s, last seen %s", ErrClockTampered,
+			now.UTC().Format(time.RFC3339), g.mark.UTC().Format(time.RFC3339))
+	}
+	if now.Sub(g.mark) >= g.cfg.Resolution {
+		g.mark = now
+		g.save(now)
+	}
+	return nil
+}
+
+// Raise 把高水位推进到 t，已经更晚时不变；用于把签名保护的时间（例如许可证签发时间）计入高水位，
+// 删除记录后高水位也不会低于这些时间
+func (g *ClockGuard) Raise(t time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if t.After(g.mark) {
+		g.mark = t
+		g.save(t)
+	}
+}
+
+func (g *ClockGuard) copies() int {
+	if g.cfg.BackupPath != "" {
+		return 2
+	}
+	return 1
+}
+
+func (g *ClockGuard) mac(t int64) string {
+	m := hmac.New(sha256.New, g.cfg.Key)
+	fmt.Fprintf(m, "%d", t)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// load 读取时间记录和副本，取两者中较晚的时间；found 为实际存在的份数
+func (g *ClockGuard) load() (mark time.Time, found int, err error) {
+	for _, path := range []string{g.cfg.Path, g.cfg.BackupPath} {
+		if path == "" {
+			continue
+		}
+		t, ok, err := g.loadFile(path)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		if ok {
+			found++
+			if t.After(mark) {
+				mark = t
+			}
+		}
+	}
+	return mark, found, nil
+}
+
+func (g *ClockGuard) loadFile(path string) (time.Time, bool, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to read clock state: %v", err)
+	}
+	var st clockState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: invalid clock state", ErrClockTampered)
+	}
+	if !hmac.Equal([]byte(st.MAC), []byte(g.mac(st.T))) {
+		return time.Time{}, false, fmt.Errorf("%w: clock state integrity check failed", ErrClockTampered)
+	}
+	return time.Unix(0, st.T), true, nil
+}
+
+// save 写入时间记录和副本
+func (g *ClockGuard) save(t time.Time) error {
+	st := clockState{T: t.UnixNano()}
+	st.MAC = g.mac(st.T)
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(g.cfg.Path, b); err != nil {
+		return err
+	}
+	if g.cfg.BackupPath != "" {
+		return writeFileAtomic(g.cfg.BackupPath, b)
+	}
+	return nil
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免写到一半的记录被当作篡改
+func writeFileAtomic(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
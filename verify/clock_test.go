@@ -0,0 +1,176 @@
+package verify
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeriveClockKey(t *testing.T) {
+	a := DeriveClockKey([]byte("product secret"))
+	if !bytes.Equal(a, DeriveClockKey([]byte("product secret"))) {
+		t.Fatal("DeriveClockKey() is not deterministic")
+	}
+	if bytes.Equal(a, DeriveClockKey([]byte("other secret"))) {
+		t.Fatal("different secrets derive the same key")
+	}
+	if len(a) != 32 || bytes.Contains(a, []byte("product secret")) {
+		t.Fatalf("unexpected derived key %x", a)
+	}
+}
+
+func TestClockGuard(t *testing.T) {
+	key := DeriveClockKey([]byte("product secret"))
+	dir := t.TempDir()
+	cfg := ClockGuardConfig{Path: filepath.Join(dir, "clock.json"), Key: key, Tolerance: time.Hour}
+	g, err := NewClockGuard(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 首次运行写入当前时间
+	if _, err := os.Stat(cfg.Path); err != nil {
+		t.Fatalf("clock state was not written: %v", err)
+	}
+	later := time.Now().Add(48 * time.Hour)
+	if err := g.Observe(later); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Observe(later.Add(-30 * time.Minute)); err != nil {
+		t.Fatalf("rollback within tolerance: %v", err)
+	}
+	if err := g.Observe(later.Add(-2 * time.Hour)); !errors.Is(err, ErrClockTampered) {
+		t.Fatalf("Observe() error = %v, want %v", err, ErrClockTampered)
+	}
+
+	// 重新加载后高水位仍然有效
+	g, err = NewClockGuard(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Observe(time.Now()); !errors.Is(err, ErrClockTampered) {
+		t.Fatalf("Observe() after reload error = %v, want %v", err, ErrClockTampered)
+	}
+
+	// 其他密钥无法验证记录
+	other := cfg
+	other.Key = DeriveClockKey([]byte("other secret"))
+	if _, err := NewClockGuard(other); !errors.Is(err, ErrClockTampered) {
+		t.Fatalf("NewClockGuard() with another key error = %v, want %v", err, ErrClockTampered)
+	}
+}
+
+func TestClockGuardTamperedState(t *testing.T) {
+	key := DeriveClockKey([]byte("product secret"))
+	path := filepath.Join(t.TempDir(), "clock.json")
+	if _, err := NewClockGuard(ClockGuardConfig{Path: path, Key: key}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{
+		"changed time": bytes.Replace(b, []byte(`"t":1`), []byte(`"t":0`), 1),
+		"not json":     []byte("garbage"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := os.WriteFile(path, content, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewClockGuard(ClockGuardConfig{Path: path, Key: key}); !errors.Is(err, ErrClockTampered) {
+				t.Fatalf("NewClockGuard() error = %v, want %v", err, ErrClockTampered)
+			}
+		})
+	}
+}
+
+func TestClockGuardBackup(t *testing.T) {
+	dir := t.TempDir()
+	cfg := ClockGuardConfig{
+		Path:       filepath.Join(dir, "a", "clock.json"),
+		BackupPath: filepath.Join(dir, "b", "clock.json"),
+		Key:        DeriveClockKey([]byte("product secret")),
+	}
+	g, err := NewClockGuard(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mark := time.Now().Add(72 * time.Hour)
+	g.Raise(mark)
+
+	// 删除一份后按另一份恢复
+	if err := os.Remove(cfg.Path); err != nil {
+		t.Fatal(err)
+	}
+	g, err = NewClockGuard(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cfg.Path); err != nil {
+		t.Fatalf("clock state was not restored: %v", err)
+	}
+	if err := g.Observe(time.Now()); !errors.Is(err, ErrClockTampered) {
+		t.Fatalf("Observe() error = %v, want %v", err, ErrClockTampered)
+	}
+}
+
+func TestClockGuardInstallKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clock.json")
+	if _, err := NewClockGuard(ClockGuardConfig{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".key"); err != nil {
+		t.Fatalf("install key was not written: %v", err)
+	}
+	// 安装密钥还在而时间记录被删除
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClockGuard(ClockGuardConfig{Path: path}); !errors.Is(err, ErrClockTampered) {
+		t.Fatalf("NewClockGuard() error = %v, want %v", err, ErrClockTampered)
+	}
+}
+
+func TestVerifierClockGuard(t *testing.T) {
+	key, _ := testKeys(t)
+	guard, err := NewClockGuard(ClockGuardConfig{
+		Path: filepath.Join(t.TempDir(), "clock.json"),
+		Key:  DeriveClockKey([]byte("product secret")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	clock := func() time.Time { return now }
+	v := testVerifier(t, VerifierConfig{Clock: clock, ClockGuard: guard})
+
+	c := validClaims()
+	c.Iat = now.Add(-time.Hour).Unix()
+	c.Exp = now.Add(30 * 24 * time.Hour).Unix()
+	if _, err := v.VerifyToken(sign(t, key, "", c)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 本机时间早于签发时间超过容忍范围
+	future := validClaims()
+	future.Iat = now.Add(24 * time.Hour).Unix()
+	future.Exp = now.Add(30 * 24 * time.Hour).Unix()
+	if _, err := v.VerifyToken(sign(t, key, "", future)); !errors.Is(err, ErrClockTampered) {
+		t.Fatalf("VerifyToken() error = %v, want %v", err, ErrClockTampered)
+	}
+
+	// 容忍范围内的签发时间计入高水位，之后回拨到签发时间之前超过容忍范围，
+	// 签发较早的许可证也无法继续使用
+	recent := c
+	recent.Iat = now.Add(10 * time.Minute).Unix()
+	if _, err := v.VerifyToken(sign(t, key, "", recent)); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(-10 * time.Minute)
+	if _, err := v.VerifyToken(sign(t, key, "", c)); !errors.Is(err, ErrClockTampered) {
+		t.Fatalf("VerifyToken() after the mark was raised error = %v, want %v", err, ErrClockTampered)
+	}
+}
@@ -0,0 +1,186 @@
+package verify
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ------------------ License File Envelope ------------------
+
+// 许可证文件格式：
+//
+//	-----BEGIN LICENSE-----
+//	Version: 1
+//	Product: license
+//
+//	<许可证（JWS/JWE）的 base64，每行 64 字符>
+//	=<CRC-24 校验和>
+//	-----END LICENSE-----
+//
+// 复制粘贴造成的截断、丢行、改字可以在解析签名之前给出明确的错误提示
+const (
+	licenseLabel       = "LICENSE"
+	licenseFileVersion = 1
+	armorLineWidth     = 64
+)
+
+// LicenseFile 解析后的许可证文件
+type LicenseFile struct {
+	Version int
+	Product string
+	Token   string // 紧凑序列化的 JWS 或 JWE
+	Legacy  bool   // 旧格式：文件内容就是裸 JWS
+}
+
+// EncodeLicenseFile 将许可证封装为带校验和的文本格式
+func EncodeLicenseFile(token, product string) string {
+	return EncodeArmor(licenseLabel, licenseFileVersion, product, []byte(strings.TrimSpace(token)))
+}
+
+// DecodeLicenseFile 解析许可证文件，同时兼容旧的裸 JWS/JWE 格式
+func DecodeLicenseFile(data string) (*LicenseFile, error) {
+	data = strings.TrimSpace(strings.TrimPrefix(data, "\ufeff"))
+	if data == "" {
+		return nil, errors.New("license file is empty")
+	}
+	if !strings.HasPrefix(data, armorBegin(licenseLabel)) && !strings.Contains(data, "BEGIN "+licenseLabel) {
+		// 旧格式，去掉复制时可能引入的换行和空白
+		return &LicenseFile{Token: strings.Join(strings.Fields(data), ""), Legacy: true}, nil
+	}
+
+	version, product, raw, err := DecodeArmor(licenseLabel, data)
+	if err != nil {
+		return nil, err
+	}
+	if version != licenseFileVersion {
+		return nil, fmt.Errorf("unsupported license file version: %d", version)
+	}
+	return &LicenseFile{Version: version, Product: product, Token: string(raw)}, nil
+}
+
+// ------------------ Armor ------------------
+
+func armorBegin(label string) string {
+	return "-----BEGIN " + label + "-----"
+}
+
+func armorEnd(label string) string {
+	return "-----END " + label + "-----"
+}
+
+// EncodeArmor 文本封装，许可证文件和离线激活请求共用：BEGIN/END 行、Version/Product 头、按行折叠的 base64 正文和 CRC-24
+func EncodeArmor(label string, version int, product string, data []byte) string {
+	body := base64.StdEncoding.EncodeToString(data)
+
+	var sb strings.Builder
+	sb.WriteString(armorBegin(label) + "\n")
+	sb.WriteString("Version: " + strconv.Itoa(version) + "\n")
+	if product != "" {
+		sb.WriteString("Product: " + product + "\n")
+	}
+	sb.WriteString("\n")
+	for len(body) > armorLineWidth {
+		sb.WriteString(body[:armorLineWidth] + "\n")
+		body = body[armorLineWidth:]
+	}
+	sb.WriteString(body + "\n")
+	sb.WriteString("=" + encodeCRC24(crc24(data)) + "\n")
+	sb.WriteString(armorEnd(label) + "\n")
+	return sb.String()
+}
+
+// DecodeArmor 解析文本封装并校验 CRC-24，错误信息指出具体的损坏位置
+func DecodeArmor(label, text string) (version int, product string, data []byte, err error) {
+	name := strings.ToLower(label)
+	text = strings.TrimSpace(strings.TrimPrefix(text, "\ufeff"))
+	if !strings.HasPrefix(text, armorBegin(label)) {
+		return 0, "", nil, fmt.Errorf("%s is damaged: BEGIN %s line is incomplete", name, label)
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if strings.TrimSpace(lines[len(lines)-1]) != armorEnd(label) {
+		return 0, "", nil, fmt.Errorf("%s is truncated: missing END %s line", name, label)
+	}
+	lines = lines[1 : len(lines)-1]
+
+	// 头部，以空行结束；空行在复制时丢失也能继续解析（base64 正文不含冒号）
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			i++
+			break
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			break
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "Version":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, "", nil, fmt.Errorf("%s is damaged: invalid version %q", name, value)
+			}
+			version = v
+		case "Product":
+			product = value
+		}
+	}
+
+	// 正文和校验和
+	var body strings.Builder
+	checksum := ""
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "=") {
+			checksum = line[1:]
+			continue
+		}
+		if checksum != "" {
+			return 0, "", nil, fmt.Errorf("%s is damaged: data after checksum line", name)
+		}
+		body.WriteString(line)
+	}
+	if checksum == "" {
+		return 0, "", nil, fmt.Errorf("%s is damaged: missing checksum line", name)
+	}
+
+	data, err = base64.StdEncoding.DecodeString(body.String())
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("%s is damaged: invalid base64 data (%v)", name, err)
+	}
+	if encodeCRC24(crc24(data)) != checksum {
+		return 0, "", nil, fmt.Errorf("%s is damaged: checksum mismatch, please copy the whole file again", name)
+	}
+	return version, product, data, nil
+}
+
+// crc24 OpenPGP ASCII Armor 使用的 CRC-24 (RFC 4880 6.1)
+func crc24(data []byte) uint32 {
+	const (
+		crc24Init = 0xB704CE
+		crc24Poly = 0x1864CFB
+	)
+	crc := uint32(crc24Init)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+	return crc & 0xFFFFFF
+}
+
+func encodeCRC24(crc uint32) string {
+	return base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)})
+}
@@ -0,0 +1,88 @@
+package verify
+
+import (
+	"sort"
+	"time"
+
+	"license/hwid"
+)
+
+// ------------------ 许可证与本机比较 ------------------
+
+// ComponentDiff 许可证绑定的组件哈希与本机组件哈希的比较
+type ComponentDiff struct {
+	Name   string `json:"name"`
+	Bound  string `json:"bound"`
+	Local  string `json:"local,omitempty"` // 本机无法获取该组件时为空
+	Match  bool   `json:"match"`
+	Weight int    `json:"weight"`
+}
+
+// Comparison 许可证与本机指纹的比较结果
+type Comparison struct {
+	Customer           string `json:"customer"`
+	Expired            bool   `json:"expired"`
+	Binding            string `json:"binding"`
+	Scheme             int    `json:"scheme"`
+	Salt               string `json:"salt,omitempty"`
+	LicenseFingerprint string `json:"licenseFingerprint"`
+	LocalFingerprint   string `json:"localFingerprint"` // 本机指纹的前 80 位（hex），与 LicenseFingerprint 对应
+	// Match 与 CheckFingerprint 的结论一致，Reason 为不匹配的原因
+	Match  bool   `json:"match"`
+	Reason string `json:"reason,omitempty"`
+	// Components 许可证携带组件哈希时逐个比较，Score 达到 Threshold 即视为同一台机器
+	Components []ComponentDiff `json:"components,omitempty"`
+	Score      int             `json:"score,omitempty"`
+	Threshold  int             `json:"threshold,omitempty"`
+	Note       string          `json:"note,omitempty"`
+	// Local 按许可证的绑定方式和算法版本计算的本机指纹组成
+	Local *hwid.Explanation `json:"local"`
+}
+
+// Compare 说明许可证为什么与本机匹配或不匹配：许可证携带组件哈希时逐个比较，
+// 否则只能比较整体指纹，配合 Local 中各组件的来源排查
+func Compare(cl *Claims) *Comparison {
+	binding := cl.Binding
+	if binding == "" {
+		binding = hwid.BindingMachine
+	}
+	cmp := &Comparison{
+		Customer:           cl.Customer,
+		Expired:            time.Now().UTC().Unix() > cl.Exp,
+		Binding:            binding,
+		Scheme:             cl.FPScheme,
+		Salt:               cl.FPSalt,
+		LicenseFingerprint: cl.Fingerprint,
+		Match:              true,
+		Local:              hwid.Explain(binding, cl.FPScheme),
+	}
+	if cmp.Scheme == 0 {
+		cmp.Scheme = hwid.SchemeLegacy
+	}
+	if full := cmp.Local.FullFingerprint; len(full) >= 20 {
+		cmp.LocalFingerprint = full[:20]
+	}
+	if err := CheckFingerprint(cl); err != nil {
+		cmp.Match, cmp.Reason = false, err.Error()
+	}
+
+	if binding == hwid.BindingMachine && len(cl.Components) > 0 {
+		policy := hwid.DefaultMatchPolicy
+		local := hwid.GetComponents()
+		res := policy.Match(cl.Components, local)
+		for _, name := range append(res.Matched, res.Missed...) {
+			cmp.Components = append(cmp.Components, ComponentDiff{
+				Name:   name,
+				Bound:  cl.Components[name],
+				Local:  local[name],
+				Match:  local[name] == cl.Components[name],
+				Weight: policy.Weights[name],
+			})
+		}
+		sort.Slice(cmp.Components, func(i, j int) bool { return cmp.Components[i].Name < cmp.Components[j].Name })
+		cmp.Score, cmp.Threshold = res.Score, res.Threshold
+	} else if !cmp.Match {
+		cmp.Note = "license does not record per-component hashes; compare local components with the machine it was issued for"
+	}
+	return cmp
+}
@@ -0,0 +1,135 @@
+package verify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ------------------ Short License Key ------------------
+
+// 短许可证密钥，供无法粘贴 JWS 的离线机器通过电话等方式录入：
+//
+//	字节 0     格式版本
+//	字节 1-2   产品 ID
+//	字节 3-4   过期日期（自 2020-01-01 起的天数）
+//	字节 5-6   功能位
+//	字节 7-16  HMAC-SHA256(产品密钥, 字节 0-6 || 机器指纹) 截断为 80 位
+//
+// 共 17 字节，base32 编码为 28 个字符，显示为 XXXX-XXXX-XXXX-XXXX-XXXX-XXXX-XXXX
+// 非对称签名（Ed25519 64 字节）放不进这个长度，因此使用 MAC，校验方需要内置产品密钥
+const (
+	shortKeyVersion  = 1
+	shortKeyMACBytes = 10
+	shortKeyBytes    = 7 + shortKeyMACBytes
+	shortKeyChars    = 28
+)
+
+var (
+	shortKeyEpoch    = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	shortKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// ShortKey 短许可证密钥携带的信息
+type ShortKey struct {
+	ProductID uint16
+	Expiry    time.Time // 精确到天，当天 UTC 0 点过期
+	Features  uint16
+}
+
+// HasFeature 判断是否包含第 bit 个功能位
+func (k *ShortKey) HasFeature(bit uint) bool {
+	return bit < 16 && k.Features&(1<<bit) != 0
+}
+
+// IssueShortKey 签发短许可证密钥，fingerprintHex 为空时不绑定机器
+func IssueShortKey(secret []byte, k ShortKey, fingerprintHex string) (string, error) {
+	if len(secret) == 0 {
+		return "", errors.New("short key secret is not configured")
+	}
+	// 向上取整到天，避免比申请的有效期短
+	days := math.Ceil(k.Expiry.UTC().Sub(shortKeyEpoch).Hours() / 24)
+	if days < 0 || days > 0xFFFF {
+		return "", errors.New("short key expiry out of range")
+	}
+	fp, err := decodeFingerprintHex(fingerprintHex)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, shortKeyBytes)
+	buf[0] = shortKeyVersion
+	binary.BigEndian.PutUint16(buf[1:3], k.ProductID)
+	binary.BigEndian.PutUint16(buf[3:5], uint16(days))
+	binary.BigEndian.PutUint16(buf[5:7], k.Features)
+	copy(buf[7:], shortKeyMAC(secret, buf[:7], fp))
+
+	s := shortKeyEncoding.EncodeToString(buf)
+	var parts []string
+	for i := 0; i < len(s); i += 4 {
+		parts = append(parts, s[i:i+4])
+	}
+	return strings.Join(parts, "-"), nil
+}
+
+// VerifyShortKey 校验短许可证密钥的 MAC、机器指纹和过期时间，fingerprintHex 为本机指纹
+func VerifyShortKey(secret []byte, code, fingerprintHex string, now time.Time) (*ShortKey, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("short key secret is not configured")
+	}
+	s := strings.ToUpper(strings.ReplaceAll(strings.ReplaceAll(code, "-", ""), " ", ""))
+	if len(s) != shortKeyChars {
+		return nil, fmt.Errorf("short key must be %d base32 chars", shortKeyChars)
+	}
+	buf, err := shortKeyEncoding.DecodeString(s)
+	if err != nil || len(buf) != shortKeyBytes {
+		return nil, errors.New("short key contains invalid characters")
+	}
+	if buf[0] != shortKeyVersion {
+		return nil, fmt.Errorf("unsupported short key version: %d", buf[0])
+	}
+	fp, err := decodeFingerprintHex(fingerprintHex)
+	if err != nil {
+		return nil, err
+	}
+	// 不绑定机器的密钥和绑定本机的密钥都接受
+	if !hmac.Equal(buf[7:], shortKeyMAC(secret, buf[:7], nil)) &&
+		!hmac.Equal(buf[7:], shortKeyMAC(secret, buf[:7], fp)) {
+		return nil, errors.New("invalid short key or fingerprint mismatch")
+	}
+
+	k := &ShortKey{
+		ProductID: binary.BigEndian.Uint16(buf[1:3]),
+		Expiry:    shortKeyEpoch.AddDate(0, 0, int(binary.BigEndian.Uint16(buf[3:5]))),
+		Features:  binary.BigEndian.Uint16(buf[5:7]),
+	}
+	if !now.Before(k.Expiry) {
+		return nil, ErrExpired
+	}
+	return k, nil
+}
+
+func shortKeyMAC(secret, payload, fingerprint []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	mac.Write(fingerprint)
+	return mac.Sum(nil)[:shortKeyMACBytes]
+}
+
+func decodeFingerprintHex(fingerprintHex string) ([]byte, error) {
+	if fingerprintHex == "" {
+		return nil, nil
+	}
+	fp, err := hex.DecodeString(fingerprintHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fingerprint: %v", err)
+	}
+	return fp, nil
+}
@@ -0,0 +1,234 @@
+package verify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/square/go-jose/v3"
+)
+
+// ------------------ 可信时间 ------------------
+
+var (
+	// ErrTrustedTimeUnavailable 无法获得可信时间且策略不允许回退
+	ErrTrustedTimeUnavailable = errors.New("trusted time unavailable")
+	// ErrInvalidTimestamp 签名时间戳格式正确但内容不可用，例如时间为 0 或早于许可证签发时间
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+)
+
+const (
+	// TimestampType 签名时间戳的 JWS typ 头，许可证不带此头，两者不能互换使用
+	TimestampType = "license-time+jwt"
+	// TimestampSubject 签名时间戳的 sub
+	TimestampSubject = "timestamp"
+)
+
+// Timestamp 许可证服务器签名的时间戳
+type Timestamp struct {
+	Sub   string `json:"sub"`
+	Time  int64  `json:"time"` // Unix 秒
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// TimeSource 可信时间来源，返回包含 nonce 的签名时间戳
+type TimeSource interface {
+	Timestamp(ctx context.Context, nonce string) (string, error)
+}
+
+// TimePolicy 无法获得可信时间时的处理方式
+type TimePolicy int
+
+const (
+	// TimeFallbackLocal 使用本机时间（默认）
+	TimeFallbackLocal TimePolicy = iota
+	// TimeFallbackLastKnown 使用最近一次可信时间加上之后经过的单调时间，从未获得过可信时间时失败
+	TimeFallbackLastKnown
+	// TimeRequired 必须获得可信时间，否则返回 ErrTrustedTimeUnavailable
+	TimeRequired
+)
+
+// RemoteTime 从许可证服务器的 /api/time 获取签名时间戳
+type RemoteTime struct {
+	URL    string       // 例如 https://license.example.com/api/time
+	Client *http.Client // 为空时使用 10 秒超时的客户端
+}
+
+func (s RemoteTime) Timestamp(ctx context.Context, nonce string) (string, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("nonce", nonce)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch timestamp: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch timestamp: %s", resp.Status)
+	}
+	var out struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&out); err != nil {
+		return "", fmt.Errorf("invalid timestamp response: %v", err)
+	}
+	return out.Token, nil
+}
+
+// trustedClock 缓存最近一次可信时间，之后按单调时间推算；同时记录最近一次获取失败
+type trustedClock struct {
+	mu      sync.Mutex
+	at      time.Time // 可信时间
+	fetched time.Time // 获得可信时间时的本机时间，带单调时钟读数
+	failed  time.Time // 最近一次获取失败的本机时间，带单调时钟读数
+	failErr error
+}
+
+func (t *trustedClock) get() (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fetched.IsZero() {
+		return time.Time{}, false
+	}
+	return t.at.Add(time.Since(t.fetched)), true
+}
+
+func (t *trustedClock) age() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fetched.IsZero() {
+		return -1
+	}
+	return time.Since(t.fetched)
+}
+
+func (t *trustedClock) set(at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.at, t.fetched = at, time.Now()
+	t.failed, t.failErr = time.Time{}, nil
+}
+
+func (t *trustedClock) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failed, t.failErr = time.Now(), err
+}
+
+// recentFailure 距离上次获取失败不超过 retry 时返回当时的错误
+func (t *trustedClock) recentFailure(retry time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failErr == nil || time.Since(t.failed) >= retry {
+		return nil
+	}
+	return t.failErr
+}
+
+// VerifyTimestamp 验证签名时间戳，nonce 非空时必须与时间戳中的 nonce 一致
+func (v *Verifier) VerifyTimestamp(token, nonce string) (time.Time, error) {
+	signed, err := jose.ParseSigned(token)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if jwsType(signed) != TimestampType {
+		return time.Time{}, fmt.Errorf("%w: not a timestamp", ErrMalformed)
+	}
+	out, err := signed.Verify(v.keyFor(signed))
+	if err != nil {
+		if out, err = v.tryKeys(signed); err != nil {
+			return time.Time{}, ErrBadSignature
+		}
+	}
+	var ts Timestamp
+	if err := json.Unmarshal(out, &ts); err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if ts.Sub != TimestampSubject {
+		return time.Time{}, fmt.Errorf("%w: not a timestamp", ErrMalformed)
+	}
+	if ts.Time <= 0 {
+		return time.Time{}, fmt.Errorf("%w: missing time", ErrInvalidTimestamp)
+	}
+	if nonce != "" && ts.Nonce != nonce {
+		return time.Time{}, errors.New("timestamp nonce mismatch")
+	}
+	return time.Unix(ts.Time, 0).UTC(), nil
+}
+
+// jwsType 返回第一个签名的 typ 头
+func jwsType(signed *jose.JSONWebSignature) string {
+	if len(signed.Signatures) == 0 {
+		return ""
+	}
+	typ, _ := signed.Signatures[0].Header.ExtraHeaders[jose.HeaderType].(string)
+	return typ
+}
+
+// now 校验使用的当前时间：配置了可信时间来源时优先使用可信时间，获取失败时按策略回退，
+// 失败后 TimeRetry 内不再请求；trusted 表示返回的是可信时间而不是本机时间
+func (v *Verifier) now(ctx context.Context) (t time.Time, trusted bool, err error) {
+	if v.timeSource == nil {
+		return v.clock(), false, nil
+	}
+	if age := v.trusted.age(); age >= 0 && age < v.timeRefresh {
+		t, _ := v.trusted.get()
+		return t, true, nil
+	}
+	if err := v.trusted.recentFailure(v.timeRetry); err != nil {
+		return v.fallbackTime(err)
+	}
+	t, err = v.fetchTime(ctx)
+	if err == nil {
+		v.trusted.set(t)
+		return t, true, nil
+	}
+	v.trusted.fail(err)
+	return v.fallbackTime(err)
+}
+
+// fallbackTime 无法获得可信时间时按 TimePolicy 处理
+func (v *Verifier) fallbackTime(err error) (time.Time, bool, error) {
+	switch v.timePolicy {
+	case TimeFallbackLocal:
+		return v.clock(), false, nil
+	case TimeFallbackLastKnown:
+		if t, ok := v.trusted.get(); ok {
+			return t, true, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("%w: %v", ErrTrustedTimeUnavailable, err)
+}
+
+func (v *Verifier) fetchTime(ctx context.Context) (time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Time{}, err
+	}
+	nonce := hex.EncodeToString(b)
+	token, err := v.timeSource.Timestamp(ctx, nonce)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return v.VerifyTimestamp(token, nonce)
+}
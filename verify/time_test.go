@@ -0,0 +1,244 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/square/go-jose/v3"
+)
+
+// signTimestamp 用测试密钥签发时间戳
+func signTimestamp(t testing.TB, ts Timestamp) string {
+	t.Helper()
+	key, _ := testKeys(t)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType(TimestampType))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// fakeTimeSource 返回固定时间的签名时间戳，err 非空时获取失败；calls 记录请求次数
+type fakeTimeSource struct {
+	t     testing.TB
+	at    time.Time
+	err   atomic.Pointer[error]
+	calls atomic.Int32
+}
+
+func (s *fakeTimeSource) Timestamp(ctx context.Context, nonce string) (string, error) {
+	s.calls.Add(1)
+	if err := s.err.Load(); err != nil {
+		return "", *err
+	}
+	return signTimestamp(s.t, Timestamp{Sub: TimestampSubject, Time: s.at.Unix(), Nonce: nonce}), nil
+}
+
+func (s *fakeTimeSource) fail(err error) { s.err.Store(&err) }
+
+func TestVerifyTimestamp(t *testing.T) {
+	key, other := testKeys(t)
+	v := testVerifier(t, VerifierConfig{})
+	untrusted := func() string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: other},
+			(&jose.SignerOptions{}).WithType(TimestampType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := signer.Sign([]byte(`{"sub":"timestamp","time":1700000000}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _ := obj.CompactSerialize()
+		return token
+	}()
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+		want  error // errAny 表示非指定类型的错误
+	}{
+		{"valid", signTimestamp(t, Timestamp{Sub: TimestampSubject, Time: testNow.Unix(), Nonce: "n1"}), "n1", nil},
+		{"nonce not checked", signTimestamp(t, Timestamp{Sub: TimestampSubject, Time: testNow.Unix()}), "", nil},
+		{"nonce mismatch", signTimestamp(t, Timestamp{Sub: TimestampSubject, Time: testNow.Unix(), Nonce: "n1"}), "n2", errAny},
+		{"missing time", signTimestamp(t, Timestamp{Sub: TimestampSubject}), "", ErrInvalidTimestamp},
+		{"wrong subject", signTimestamp(t, Timestamp{Sub: LicenseSubject, Time: testNow.Unix()}), "", ErrMalformed},
+		{"license is not a timestamp", sign(t, key, "", validClaims()), "", ErrMalformed},
+		{"untrusted key", untrusted, "", ErrBadSignature},
+		{"malformed", "garbage", "", ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, err := v.VerifyTimestamp(tt.token, tt.nonce)
+			switch {
+			case tt.want == nil:
+				if err != nil {
+					t.Fatalf("VerifyTimestamp() error = %v", err)
+				}
+				if !at.Equal(testNow) {
+					t.Fatalf("VerifyTimestamp() = %v, want %v", at, testNow)
+				}
+			case tt.want == errAny:
+				if err == nil {
+					t.Fatal("VerifyTimestamp() should fail")
+				}
+			case !errors.Is(err, tt.want):
+				t.Fatalf("VerifyTimestamp() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimestampIsNotALicense(t *testing.T) {
+	ts := signTimestamp(t, Timestamp{Sub: TimestampSubject, Time: testNow.Unix()})
+	if _, err := testVerifier(t, VerifierConfig{}).VerifyToken(ts); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("VerifyToken() error = %v, want %v", err, ErrMalformed)
+	}
+}
+
+func TestVerifyAt(t *testing.T) {
+	key, _ := testKeys(t)
+	v := testVerifier(t, VerifierConfig{})
+	token := sign(t, key, "", validClaims())
+	at := func(t0 time.Time) string {
+		return signTimestamp(t, Timestamp{Sub: TimestampSubject, Time: t0.Unix()})
+	}
+	if _, err := v.VerifyAt(token, at(testNow)); err != nil {
+		t.Fatal(err)
+	}
+	// 本机时间有效，签名时间已经过期
+	if _, err := v.VerifyAt(token, at(testNow.Add(48*time.Hour))); !errors.Is(err, ErrExpired) {
+		t.Fatalf("VerifyAt() error = %v, want %v", err, ErrExpired)
+	}
+	// 早于签发时间的时间戳是被重放的旧时间戳
+	if _, err := v.VerifyAt(token, at(testNow.Add(-2*time.Hour))); !errors.Is(err, ErrInvalidTimestamp) {
+		t.Fatalf("VerifyAt() error = %v, want %v", err, ErrInvalidTimestamp)
+	}
+	if _, err := v.VerifyAt(token, "garbage"); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("VerifyAt() error = %v, want %v", err, ErrMalformed)
+	}
+}
+
+func TestTrustedTime(t *testing.T) {
+	key, _ := testKeys(t)
+	token := sign(t, key, "", validClaims())
+
+	// 按可信时间判断有效期，刷新间隔内不重复请求
+	src := &fakeTimeSource{t: t, at: testNow.Add(48 * time.Hour)}
+	v := testVerifier(t, VerifierConfig{TrustedTime: src})
+	for i := 0; i < 3; i++ {
+		if _, err := v.VerifyToken(token); !errors.Is(err, ErrExpired) {
+			t.Fatalf("VerifyToken() error = %v, want %v", err, ErrExpired)
+		}
+	}
+	if n := src.calls.Load(); n != 1 {
+		t.Fatalf("time source called %d times, want 1", n)
+	}
+}
+
+func TestTrustedTimePolicy(t *testing.T) {
+	key, _ := testKeys(t)
+	token := sign(t, key, "", validClaims())
+	unavailable := errors.New("connection refused")
+	tests := []struct {
+		name   string
+		policy TimePolicy
+		known  bool // 获取失败前是否获得过可信时间
+		want   error
+	}{
+		{"local", TimeFallbackLocal, false, nil},
+		{"last known", TimeFallbackLastKnown, true, ErrExpired},
+		{"last known never fetched", TimeFallbackLastKnown, false, ErrTrustedTimeUnavailable},
+		{"required", TimeRequired, true, ErrTrustedTimeUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 可信时间已经过期，本机时间有效
+			src := &fakeTimeSource{t: t, at: testNow.Add(48 * time.Hour)}
+			v := testVerifier(t, VerifierConfig{TrustedTime: src, TimePolicy: tt.policy, TimeRefresh: time.Nanosecond})
+			if tt.known {
+				v.VerifyToken(token)
+			}
+			src.fail(unavailable)
+			_, err := v.VerifyToken(token)
+			if tt.want == nil && err != nil {
+				t.Fatalf("VerifyToken() error = %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyToken() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedTimeRetry(t *testing.T) {
+	key, _ := testKeys(t)
+	token := sign(t, key, "", validClaims())
+	src := &fakeTimeSource{t: t, at: testNow}
+	src.fail(errors.New("connection refused"))
+	v := testVerifier(t, VerifierConfig{TrustedTime: src, TimePolicy: TimeRequired, TimeRetry: 50 * time.Millisecond})
+
+	// 获取失败后重试间隔内直接按策略回退，不再请求
+	for i := 0; i < 3; i++ {
+		if _, err := v.VerifyToken(token); !errors.Is(err, ErrTrustedTimeUnavailable) {
+			t.Fatalf("VerifyToken() error = %v, want %v", err, ErrTrustedTimeUnavailable)
+		}
+	}
+	if n := src.calls.Load(); n != 1 {
+		t.Fatalf("time source called %d times during the retry period, want 1", n)
+	}
+
+	// 重试间隔过后重新请求，成功后清除失败记录
+	time.Sleep(60 * time.Millisecond)
+	src.err.Store(nil)
+	if _, err := v.VerifyToken(token); err != nil {
+		t.Fatal(err)
+	}
+	if n := src.calls.Load(); n != 2 {
+		t.Fatalf("time source called %d times, want 2", n)
+	}
+}
+
+func TestRemoteTime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/time" {
+			http.NotFound(w, r)
+			return
+		}
+		ts := signTimestamp(t, Timestamp{Sub: TimestampSubject, Time: testNow.Unix(), Nonce: r.URL.Query().Get("nonce")})
+		json.NewEncoder(w).Encode(map[string]any{"token": ts, "time": testNow.Unix()})
+	}))
+	defer srv.Close()
+
+	v := testVerifier(t, VerifierConfig{TrustedTime: RemoteTime{URL: srv.URL + "/api/time"}})
+	now, trusted, err := v.now(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trusted || now.Sub(testNow) > time.Minute {
+		t.Fatalf("now() = %v, %v, want trusted %v", now, trusted, testNow)
+	}
+
+	if _, err := (RemoteTime{URL: srv.URL + "/missing"}).Timestamp(context.Background(), "n"); err == nil {
+		t.Fatal("Timestamp() should fail for a non-200 response")
+	}
+}
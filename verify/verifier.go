@@ -0,0 +1,350 @@
+package verify
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"license/hwid"
+
+	"github.com/square/go-jose/v3"
+)
+
+// ------------------ Verifier ------------------
+
+// RevocationList 吊销列表
+type RevocationList interface {
+	// IsRevoked 许可证编号是否已被吊销
+	IsRevoked(id string) (bool, error)
+}
+
+// RevokedIDs 内存中的吊销列表
+type RevokedIDs map[string]bool
+
+func (r RevokedIDs) IsRevoked(id string) (bool, error) { return r[id], nil }
+
+// VerifierConfig 校验器配置，PublicKeys 和 JWKS 至少配置一个
+type VerifierConfig struct {
+	// PublicKeys 受信任的签名公钥，密钥轮换期间可以同时配置新旧公钥
+	PublicKeys []*rsa.PublicKey
+	// JWKS JSON Web Key Set，其中的 RSA 公钥与 PublicKeys 一起使用，kid 与许可证头部一致时优先尝试
+	JWKS []byte
+	// EncryptionKey 产品的 A256GCM 密钥，用于解密加密的许可证
+	EncryptionKey []byte
+	// Product 本产品的名称，非空时拒绝签发给其他产品的许可证
+	Product string
+	// RequireEncrypted 只接受加密的许可证（JWE），产品配置为加密签发时开启
+	RequireEncrypted bool
+	// Clock 当前时间，默认 time.Now
+	Clock func() time.Time
+	// Fingerprint 本机指纹来源，默认 LocalFingerprint
+	Fingerprint FingerprintProvider
+	// SkipFingerprint 不校验机器绑定，用于在签发端检查许可证
+	SkipFingerprint bool
+	// Revocations 吊销列表，为空不检查
+	Revocations RevocationList
+	// Grace 过期后的宽限期，期间许可证仍然有效，License.InGrace 为 true
+	Grace time.Duration
+	// ClockGuard 时钟回拨检测，配置后当前时间早于记录的最晚时间或许可证签发时间
+	// 超过容忍范围时返回 ErrClockTampered
+	ClockGuard *ClockGuard
+	// TrustedTime 可信时间来源，配置后按签名时间戳而不是本机时间判断有效期
+	TrustedTime TimeSource
+	// TimePolicy 无法获得可信时间时的处理方式，默认回退到本机时间
+	TimePolicy TimePolicy
+	// TimeRefresh 重新获取可信时间的间隔，期间按单调时间推算，默认 1 小时
+	TimeRefresh time.Duration
+	// TimeRetry 获取可信时间失败后重新尝试的间隔，期间直接按 TimePolicy 回退，
+	// 时间服务器不可达时不会每次校验都等待请求超时，默认 1 分钟
+	TimeRetry time.Duration
+}
+
+// Verifier 许可证校验器，可以被多个 goroutine 同时使用
+type Verifier struct {
+	keys        []*rsa.PublicKey
+	keyIDs      map[string]*rsa.PublicKey
+	encKey      []byte
+	product     string
+	requireEnc  bool
+	clock       func() time.Time
+	fingerprint FingerprintProvider
+	skipFP      bool
+	crl         RevocationList
+	grace       time.Duration
+	guard       *ClockGuard
+	timeSource  TimeSource
+	timePolicy  TimePolicy
+	timeRefresh time.Duration
+	timeRetry   time.Duration
+	trusted     trustedClock
+}
+
+// License 校验通过的许可证
+type License struct {
+	ID          string
+	Issuer      string
+	Customer    string
+	Product     string
+	Fingerprint string
+	Binding     string
+	VMPolicy    string
+	Features    []string
+	IssuedAt    time.Time
+	NotBefore   time.Time // 为零表示签发后立即生效
+	ExpiresAt   time.Time
+	// InGrace 许可证已过期但仍在宽限期内
+	InGrace bool
+	// ValidUntil 宽限期结束时间，没有宽限期时等于 ExpiresAt
+	ValidUntil time.Time
+	// Source 许可证的来源，由读取许可证的一方填写，例如 file:/etc/app/license.lic
+	Source string
+	// Claims 原始声明
+	Claims *Claims
+}
+
+// HasFeature 许可证是否授权了该功能
+func (l *License) HasFeature(name string) bool {
+	for _, f := range l.Features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RemainingDays 距离到期的天数，向上取整，已到期时为 0
+func (l *License) RemainingDays(now time.Time) int {
+	remaining := l.ExpiresAt.Sub(now)
+	if remaining <= 0 {
+		return 0
+	}
+	return int((remaining + 24*time.Hour - 1) / (24 * time.Hour))
+}
+
+// NewVerifier 创建校验器
+func NewVerifier(cfg VerifierConfig) (*Verifier, error) {
+	v := &Verifier{
+		keyIDs:      map[string]*rsa.PublicKey{},
+		encKey:      cfg.EncryptionKey,
+		product:     cfg.Product,
+		requireEnc:  cfg.RequireEncrypted,
+		clock:       cfg.Clock,
+		fingerprint: cfg.Fingerprint,
+		skipFP:      cfg.SkipFingerprint,
+		crl:         cfg.Revocations,
+		grace:       cfg.Grace,
+		guard:       cfg.ClockGuard,
+		timeSource:  cfg.TrustedTime,
+		timePolicy:  cfg.TimePolicy,
+		timeRefresh: cfg.TimeRefresh,
+		timeRetry:   cfg.TimeRetry,
+	}
+	for _, k := range cfg.PublicKeys {
+		if k != nil {
+			v.keys = append(v.keys, k)
+		}
+	}
+	if len(cfg.JWKS) > 0 {
+		var set jose.JSONWebKeySet
+		if err := json.Unmarshal(cfg.JWKS, &set); err != nil {
+			return nil, fmt.Errorf("invalid jwks: %v", err)
+		}
+		for _, k := range set.Keys {
+			pub, ok := k.Key.(*rsa.PublicKey)
+			if !ok {
+				continue
+			}
+			v.keys = append(v.keys, pub)
+			if k.KeyID != "" {
+				v.keyIDs[k.KeyID] = pub
+			}
+		}
+	}
+	if len(v.keys) == 0 {
+		return nil, errors.New("no rsa public key configured")
+	}
+	if v.clock == nil {
+		v.clock = time.Now
+	}
+	if v.fingerprint == nil {
+		v.fingerprint = LocalFingerprint{}
+	}
+	if v.timeRefresh <= 0 {
+		v.timeRefresh = time.Hour
+	}
+	if v.timeRetry <= 0 {
+		v.timeRetry = time.Minute
+	}
+	if cfg.Grace < 0 {
+		return nil, errors.New("grace period must not be negative")
+	}
+	return v, nil
+}
+
+// Verify 校验许可证文件（带封装或裸令牌）：签名、吊销、有效期、机器绑定和虚拟机策略
+func (v *Verifier) Verify(content string) (*License, error) {
+	lf, err := DecodeLicenseFile(content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return v.VerifyToken(lf.Token)
+}
+
+// VerifyAt 按调用方提供的签名时间戳判断有效期，例如客户端随请求带来的 /api/time 令牌；
+// 提供的时间戳可以被重放，需要同时配置 ClockGuard 防止用旧时间戳回拨
+func (v *Verifier) VerifyAt(content, timestamp string) (*License, error) {
+	at, err := v.VerifyTimestamp(timestamp, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	lf, err := DecodeLicenseFile(content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return v.verifyToken(lf.Token, &at)
+}
+
+// VerifyToken 校验紧凑序列化的 JWS 或 JWE
+func (v *Verifier) VerifyToken(token string) (*License, error) {
+	return v.verifyToken(token, nil)
+}
+
+// verifyToken at 为空时使用可信时间或本机时间
+func (v *Verifier) verifyToken(token string, at *time.Time) (*License, error) {
+	cl, jws, err := v.decode(token)
+	if err != nil {
+		return nil, err
+	}
+	if v.product != "" && cl.Product != v.product {
+		return nil, fmt.Errorf("%w: %q", ErrProductMismatch, cl.Product)
+	}
+	id := cl.ID
+	if id == "" {
+		h := sha256.Sum256([]byte(jws))
+		id = hex.EncodeToString(h[:16])
+	}
+	if v.crl != nil {
+		revoked, err := v.crl.IsRevoked(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check revocation: %v", err)
+		}
+		if revoked {
+			return nil, fmt.Errorf("%w: %s", ErrRevoked, id)
+		}
+	}
+
+	l := &License{
+		ID:          id,
+		Issuer:      cl.Iss,
+		Customer:    cl.Customer,
+		Product:     cl.Product,
+		Fingerprint: cl.Fingerprint,
+		Binding:     cl.Binding,
+		VMPolicy:    cl.VMPolicy,
+		Features:    cl.Features,
+		IssuedAt:    time.Unix(cl.Iat, 0).UTC(),
+		ExpiresAt:   time.Unix(cl.Exp, 0).UTC(),
+		Claims:      cl,
+	}
+	if cl.Nbf != 0 {
+		l.NotBefore = time.Unix(cl.Nbf, 0).UTC()
+	}
+	l.ValidUntil = l.ExpiresAt.Add(v.grace)
+	var now time.Time
+	trusted := at != nil
+	if trusted {
+		now = *at
+	} else if now, trusted, err = v.now(context.Background()); err != nil {
+		return nil, err
+	}
+	// 签名时间戳不可能早于许可证签发时间，否则是旧时间戳被重放
+	if trusted && now.Before(l.IssuedAt) {
+		return nil, fmt.Errorf("%w: %s is before the license was issued", ErrInvalidTimestamp, now.UTC().Format(time.RFC3339))
+	}
+	if v.guard != nil {
+		if err := v.guard.Observe(now); err != nil {
+			return nil, err
+		}
+		// 签发时间由签名保护，本机时间不可能早于签发时间
+		if now.Before(l.IssuedAt.Add(-v.guard.Tolerance())) {
+			return nil, fmt.Errorf("%w: now %s is before the license was issued", ErrClockTampered, now.UTC().Format(time.RFC3339))
+		}
+		v.guard.Raise(l.IssuedAt)
+	}
+	if now.Before(l.NotBefore) {
+		return nil, fmt.Errorf("%w: valid from %s", ErrNotYetValid, l.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(l.ExpiresAt) {
+		if now.After(l.ValidUntil) {
+			return nil, ErrExpired
+		}
+		l.InGrace = true
+	}
+
+	if !v.skipFP {
+		if err := checkFingerprint(cl, v.fingerprint); err != nil {
+			return nil, err
+		}
+		if err := hwid.CheckVMPolicy(cl.VMPolicy, cl.InstanceUUID); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrVMNotAllowed, err)
+		}
+	}
+	return l, nil
+}
+
+// decode 解密并验证签名，返回声明和内层 JWS
+func (v *Verifier) decode(token string) (*Claims, string, error) {
+	if isEncrypted(token) {
+		inner, err := decryptLicense(token, v.encKey)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		token = inner
+	} else if v.requireEnc {
+		return nil, "", fmt.Errorf("%w: license is not encrypted", ErrMalformed)
+	}
+	signed, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if jwsType(signed) == TimestampType {
+		return nil, "", fmt.Errorf("%w: timestamp is not a license", ErrMalformed)
+	}
+	out, err := signed.Verify(v.keyFor(signed))
+	if err != nil {
+		out, err = v.tryKeys(signed)
+		if err != nil {
+			return nil, "", ErrBadSignature
+		}
+	}
+	var c Claims
+	if err := json.Unmarshal(out, &c); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return &c, token, nil
+}
+
+// keyFor 按 kid 选择公钥，没有 kid 时返回第一个公钥
+func (v *Verifier) keyFor(signed *jose.JSONWebSignature) *rsa.PublicKey {
+	if len(signed.Signatures) > 0 {
+		if k, ok := v.keyIDs[signed.Signatures[0].Header.KeyID]; ok {
+			return k
+		}
+	}
+	return v.keys[0]
+}
+
+// tryKeys 依次尝试所有公钥
+func (v *Verifier) tryKeys(signed *jose.JSONWebSignature) ([]byte, error) {
+	for _, k := range v.keys {
+		if out, err := signed.Verify(k); err == nil {
+			return out, nil
+		}
+	}
+	return nil, ErrBadSignature
+}
@@ -0,0 +1,643 @@
+package verify
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"license/hwid"
+
+	"github.com/square/go-jose/v3"
+)
+
+// 测试共用的签名密钥：key 签发许可证，other 默认不受信任；生成 RSA 密钥较慢，只生成一次
+var (
+	keysOnce                     sync.Once
+	testSigningKey, testOtherKey *rsa.PrivateKey
+)
+
+func testKeys(t testing.TB) (key, other *rsa.PrivateKey) {
+	t.Helper()
+	keysOnce.Do(func() {
+		var err error
+		if testSigningKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			panic(err)
+		}
+		if testOtherKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			panic(err)
+		}
+	})
+	return testSigningKey, testOtherKey
+}
+
+// testNow 测试使用的固定当前时间
+var testNow = time.Unix(1_700_000_000, 0).UTC()
+
+// testDigest 本机的完整指纹
+var testDigest = func() []byte {
+	h := sha256.Sum256([]byte("test machine"))
+	return h[:]
+}()
+
+// fakeFingerprint 固定的本机指纹，digests 按指纹算法版本给出完整摘要
+type fakeFingerprint struct {
+	digests    map[int][]byte
+	components hwid.Components
+	mode       string
+	customer   string
+}
+
+func (f fakeFingerprint) Digest(binding string, scheme int) ([]byte, error) {
+	d, ok := f.digests[scheme]
+	if !ok {
+		return nil, errors.New("scheme not available")
+	}
+	return d, nil
+}
+
+func (f fakeFingerprint) Components() hwid.Components { return f.components }
+
+func (f fakeFingerprint) Salting() (string, string) { return f.mode, f.customer }
+
+// localFingerprint 只有 legacy 算法指纹的本机
+var localFingerprint = fakeFingerprint{digests: map[int][]byte{hwid.SchemeLegacy: testDigest}}
+
+// validClaims 签发给本机、当前有效的许可证声明
+func validClaims() Claims {
+	return Claims{
+		Iss:         "license-server",
+		Sub:         LicenseSubject,
+		Customer:    "acme",
+		Product:     "license",
+		Fingerprint: hex.EncodeToString(testDigest[:10]),
+		ID:          "lic-1",
+		Features:    []string{"export"},
+		Iat:         testNow.Add(-time.Hour).Unix(),
+		Exp:         testNow.Add(24 * time.Hour).Unix(),
+	}
+}
+
+// sign 用 key 签发声明，kid 非空时写入 JWS 头部
+func sign(t testing.TB, key *rsa.PrivateKey, kid string, c Claims) string {
+	t.Helper()
+	sk := jose.SigningKey{Algorithm: jose.RS256, Key: key}
+	if kid != "" {
+		sk.Key = jose.JSONWebKey{Key: key, KeyID: kid}
+	}
+	signer, err := jose.NewSigner(sk, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// encrypt 与签发端相同的方式把 JWS 加密为 JWE
+func encrypt(t testing.TB, key []byte, jws string) string {
+	t.Helper()
+	enc, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{Algorithm: jose.DIRECT, Key: key},
+		(&jose.EncrypterOptions{}).WithContentType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := enc.Encrypt([]byte(jws))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// testVerifier 信任 key、使用固定时间和本机指纹的校验器
+func testVerifier(t testing.TB, cfg VerifierConfig) *Verifier {
+	t.Helper()
+	key, _ := testKeys(t)
+	if len(cfg.PublicKeys) == 0 && len(cfg.JWKS) == 0 {
+		cfg.PublicKeys = []*rsa.PublicKey{&key.PublicKey}
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = func() time.Time { return testNow }
+	}
+	if cfg.Fingerprint == nil {
+		cfg.Fingerprint = localFingerprint
+	}
+	v, err := NewVerifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVerifierErrors(t *testing.T) {
+	key, other := testKeys(t)
+	encKey := make([]byte, 32)
+	rand.Read(encKey)
+
+	tests := []struct {
+		name   string
+		claims func(c *Claims)
+		cfg    VerifierConfig
+		token  func(c Claims) string // 为空时用 key 签发
+		want   error
+	}{
+		{name: "valid"},
+		{
+			name:   "expired",
+			claims: func(c *Claims) { c.Exp = testNow.Add(-time.Second).Unix() },
+			want:   ErrExpired,
+		},
+		{
+			name:   "expired beyond grace",
+			claims: func(c *Claims) { c.Exp = testNow.Add(-3 * time.Hour).Unix() },
+			cfg:    VerifierConfig{Grace: 2 * time.Hour},
+			want:   ErrExpired,
+		},
+		{
+			name:   "not yet valid",
+			claims: func(c *Claims) { c.Nbf = testNow.Add(time.Hour).Unix() },
+			want:   ErrNotYetValid,
+		},
+		{
+			name:  "signed by untrusted key",
+			token: func(c Claims) string { return sign(t, other, "", c) },
+			want:  ErrBadSignature,
+		},
+		{
+			name:  "tampered payload",
+			token: func(c Claims) string { return tamper(sign(t, key, "", c)) },
+			want:  ErrBadSignature,
+		},
+		{
+			name:  "malformed",
+			token: func(Claims) string { return "not-a-license" },
+			want:  ErrMalformed,
+		},
+		{
+			name: "revoked by id",
+			cfg:  VerifierConfig{Revocations: RevokedIDs{"lic-1": true}},
+			want: ErrRevoked,
+		},
+		{
+			name:   "other machine",
+			claims: func(c *Claims) { c.Fingerprint = strings.Repeat("00", 10) },
+			want:   ErrFingerprintMismatch,
+		},
+		{
+			name:   "unknown vm policy",
+			claims: func(c *Claims) { c.VMPolicy = "mainframe-only" },
+			want:   ErrVMNotAllowed,
+		},
+		{
+			name: "other product",
+			cfg:  VerifierConfig{Product: "other"},
+			want: ErrProductMismatch,
+		},
+		{
+			name:   "product required but missing",
+			claims: func(c *Claims) { c.Product = "" },
+			cfg:    VerifierConfig{Product: "license"},
+			want:   ErrProductMismatch,
+		},
+		{
+			name: "same product",
+			cfg:  VerifierConfig{Product: "license"},
+		},
+		{
+			name:  "encrypted",
+			cfg:   VerifierConfig{EncryptionKey: encKey, RequireEncrypted: true},
+			token: func(c Claims) string { return encrypt(t, encKey, sign(t, key, "", c)) },
+		},
+		{
+			name: "encryption required but signed only",
+			cfg:  VerifierConfig{EncryptionKey: encKey, RequireEncrypted: true},
+			want: ErrMalformed,
+		},
+		{
+			name:  "encrypted without key",
+			token: func(c Claims) string { return encrypt(t, encKey, sign(t, key, "", c)) },
+			want:  ErrMalformed,
+		},
+		{
+			name:  "encrypted with another key",
+			cfg:   VerifierConfig{EncryptionKey: make([]byte, 32)},
+			token: func(c Claims) string { return encrypt(t, encKey, sign(t, key, "", c)) },
+			want:  ErrMalformed,
+		},
+		{
+			name:   "fingerprint skipped",
+			claims: func(c *Claims) { c.Fingerprint = strings.Repeat("00", 10) },
+			cfg:    VerifierConfig{SkipFingerprint: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validClaims()
+			if tt.claims != nil {
+				tt.claims(&c)
+			}
+			token := ""
+			if tt.token != nil {
+				token = tt.token(c)
+			} else {
+				token = sign(t, key, "", c)
+			}
+			l, err := testVerifier(t, tt.cfg).VerifyToken(token)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("VerifyToken() error = %v", err)
+				}
+				if l.ID != "lic-1" || l.Customer != "acme" || !l.HasFeature("export") {
+					t.Fatalf("unexpected license %+v", l)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyToken() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// tamper 修改 JWS 载荷中的一个字符，签名不变
+func tamper(jws string) string {
+	parts := strings.Split(jws, ".")
+	p := []byte(parts[1])
+	if p[0] == 'A' {
+		p[0] = 'B'
+	} else {
+		p[0] = 'A'
+	}
+	parts[1] = string(p)
+	return strings.Join(parts, ".")
+}
+
+func TestVerifierLicenseFields(t *testing.T) {
+	key, _ := testKeys(t)
+	c := validClaims()
+	c.Nbf = testNow.Add(-time.Minute).Unix()
+	l, err := testVerifier(t, VerifierConfig{}).Verify(EncodeLicenseFile(sign(t, key, "", c), "license"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Product != "license" || l.Issuer != "license-server" || l.InGrace {
+		t.Fatalf("unexpected license %+v", l)
+	}
+	if !l.IssuedAt.Equal(testNow.Add(-time.Hour)) || !l.NotBefore.Equal(testNow.Add(-time.Minute)) {
+		t.Fatalf("IssuedAt = %v, NotBefore = %v", l.IssuedAt, l.NotBefore)
+	}
+	if !l.ValidUntil.Equal(l.ExpiresAt) {
+		t.Fatalf("ValidUntil = %v, want %v", l.ValidUntil, l.ExpiresAt)
+	}
+	if got := l.RemainingDays(testNow); got != 1 {
+		t.Fatalf("RemainingDays() = %d, want 1", got)
+	}
+	if got := l.RemainingDays(l.ExpiresAt.Add(time.Second)); got != 0 {
+		t.Fatalf("RemainingDays() after expiry = %d, want 0", got)
+	}
+}
+
+func TestVerifierGrace(t *testing.T) {
+	key, _ := testKeys(t)
+	c := validClaims()
+	c.Exp = testNow.Add(-time.Hour).Unix()
+	l, err := testVerifier(t, VerifierConfig{Grace: 2 * time.Hour}).VerifyToken(sign(t, key, "", c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.InGrace {
+		t.Fatal("license should be in grace period")
+	}
+	if want := l.ExpiresAt.Add(2 * time.Hour); !l.ValidUntil.Equal(want) {
+		t.Fatalf("ValidUntil = %v, want %v", l.ValidUntil, want)
+	}
+}
+
+func TestVerifierRevokedWithoutID(t *testing.T) {
+	key, _ := testKeys(t)
+	c := validClaims()
+	c.ID = ""
+	token := sign(t, key, "", c)
+	// 没有编号的旧许可证按令牌摘要识别
+	h := sha256.Sum256([]byte(token))
+	id := hex.EncodeToString(h[:16])
+	l, err := testVerifier(t, VerifierConfig{}).VerifyToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.ID != id {
+		t.Fatalf("ID = %s, want %s", l.ID, id)
+	}
+	_, err = testVerifier(t, VerifierConfig{Revocations: RevokedIDs{id: true}}).VerifyToken(token)
+	if !errors.Is(err, ErrRevoked) {
+		t.Fatalf("VerifyToken() error = %v, want %v", err, ErrRevoked)
+	}
+}
+
+// failingCRL 吊销列表不可用
+type failingCRL struct{}
+
+func (failingCRL) IsRevoked(string) (bool, error) { return false, errors.New("crl unavailable") }
+
+func TestVerifierRevocationListError(t *testing.T) {
+	key, _ := testKeys(t)
+	_, err := testVerifier(t, VerifierConfig{Revocations: failingCRL{}}).VerifyToken(sign(t, key, "", validClaims()))
+	if err == nil || errors.Is(err, ErrRevoked) {
+		t.Fatalf("VerifyToken() error = %v, want a revocation check failure", err)
+	}
+}
+
+func TestVerifierJWKS(t *testing.T) {
+	key, other := testKeys(t)
+	set, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &other.PublicKey, KeyID: "old", Algorithm: string(jose.RS256), Use: "sig"},
+		{Key: &key.PublicKey, KeyID: "new", Algorithm: string(jose.RS256), Use: "sig"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := testVerifier(t, VerifierConfig{JWKS: set})
+	untrusted, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		key  *rsa.PrivateKey
+		kid  string
+		want error
+	}{
+		{"matching kid", key, "new", nil},
+		{"rotated key", other, "old", nil},
+		{"stale kid falls back to all keys", key, "old", nil},
+		{"no kid", other, "", nil},
+		{"untrusted key", untrusted, "new", ErrBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.VerifyToken(sign(t, tt.key, tt.kid, validClaims()))
+			if tt.want == nil && err != nil {
+				t.Fatalf("VerifyToken() error = %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyToken() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewVerifierConfig(t *testing.T) {
+	key, _ := testKeys(t)
+	tests := []struct {
+		name string
+		cfg  VerifierConfig
+	}{
+		{"no keys", VerifierConfig{}},
+		{"nil key", VerifierConfig{PublicKeys: []*rsa.PublicKey{nil}}},
+		{"invalid jwks", VerifierConfig{JWKS: []byte("{")}},
+		{"negative grace", VerifierConfig{PublicKeys: []*rsa.PublicKey{&key.PublicKey}, Grace: -time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewVerifier(tt.cfg); err == nil {
+				t.Fatal("NewVerifier() should fail")
+			}
+		})
+	}
+}
+
+func TestCheckFingerprint(t *testing.T) {
+	v2 := sha256.Sum256([]byte("test machine v2"))
+	local := fakeFingerprint{
+		digests: map[int][]byte{hwid.SchemeLegacy: testDigest, hwid.SchemeV2: v2[:]},
+		components: hwid.Components{
+			hwid.ComponentMachineID: "m1",
+			hwid.ComponentMAC:       "mac1",
+			hwid.ComponentHostname:  "host1",
+		},
+	}
+	bound := func(machineID, mac, host string) hwid.Components {
+		return hwid.Components{
+			hwid.ComponentMachineID: machineID,
+			hwid.ComponentMAC:       mac,
+			hwid.ComponentHostname:  host,
+		}
+	}
+	short := hex.EncodeToString(testDigest[:10])
+	tests := []struct {
+		name  string
+		cl    Claims
+		local *fakeFingerprint
+		want  error // errAny 表示非指定类型的错误
+	}{
+		{name: "truncated fingerprint", cl: Claims{Fingerprint: short}},
+		{name: "truncated fingerprint mismatch", cl: Claims{Fingerprint: strings.Repeat("ab", 10)}, want: ErrFingerprintMismatch},
+		{name: "full fingerprint", cl: Claims{Fingerprint: "ignored", FingerprintFull: hex.EncodeToString(testDigest)}},
+		{
+			name: "full fingerprint mismatch",
+			cl:   Claims{Fingerprint: short, FingerprintFull: hex.EncodeToString(v2[:])},
+			want: ErrFingerprintMismatch,
+		},
+		{name: "invalid full fingerprint", cl: Claims{FingerprintFull: "zz"}, want: errAny},
+		{name: "scheme v2", cl: Claims{Fingerprint: hex.EncodeToString(v2[:10]), FPScheme: hwid.SchemeV2}},
+		{name: "scheme unavailable", cl: Claims{Fingerprint: short, FPScheme: 99}, want: ErrFingerprintMismatch},
+		{name: "components all match", cl: Claims{Components: bound("m1", "mac1", "host1")}},
+		{name: "components hostname changed", cl: Claims{Components: bound("m1", "mac1", "old-host")}},
+		{
+			name: "components machine-id changed",
+			cl:   Claims{Components: bound("m0", "mac1", "host1")},
+			want: ErrFingerprintMismatch,
+		},
+		{
+			name: "components with full fingerprint mismatch",
+			cl:   Claims{Components: bound("m1", "mac1", "host1"), FingerprintFull: hex.EncodeToString(v2[:])},
+			want: ErrFingerprintMismatch,
+		},
+		{
+			name: "components ignored for other bindings",
+			cl:   Claims{Components: bound("m0", "x", "y"), Binding: hwid.BindingVolume, Fingerprint: short},
+		},
+		{name: "salt mismatch", cl: Claims{Fingerprint: short, FPSalt: hwid.SaltProduct}, want: ErrFingerprintMismatch},
+		{
+			name:  "customer salt for another customer",
+			cl:    Claims{Fingerprint: short, FPSalt: hwid.SaltCustomer, Customer: "acme"},
+			local: &fakeFingerprint{digests: local.digests, mode: hwid.SaltCustomer, customer: "globex"},
+			want:  ErrFingerprintMismatch,
+		},
+		{
+			name:  "customer salt",
+			cl:    Claims{Fingerprint: short, FPSalt: hwid.SaltCustomer, Customer: "acme"},
+			local: &fakeFingerprint{digests: local.digests, mode: hwid.SaltCustomer, customer: "acme"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := local
+			if tt.local != nil {
+				fp = *tt.local
+			}
+			err := checkFingerprint(&tt.cl, fp)
+			switch {
+			case tt.want == nil:
+				if err != nil {
+					t.Fatalf("checkFingerprint() error = %v", err)
+				}
+			case tt.want == errAny:
+				if err == nil || errors.Is(err, ErrFingerprintMismatch) {
+					t.Fatalf("checkFingerprint() error = %v, want a format error", err)
+				}
+			case !errors.Is(err, tt.want):
+				t.Fatalf("checkFingerprint() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// errAny 表示期望出错但不属于任何错误类型
+var errAny = errors.New("any error")
+
+func TestLicenseFileEnvelope(t *testing.T) {
+	key, _ := testKeys(t)
+	token := sign(t, key, "", validClaims())
+	armored := EncodeLicenseFile(token, "license")
+
+	lf, err := DecodeLicenseFile(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lf.Token != token || lf.Product != "license" || lf.Version != 1 || lf.Legacy {
+		t.Fatalf("unexpected license file %+v", lf)
+	}
+	// 旧格式的裸 JWS，复制时引入的换行被去掉
+	lf, err = DecodeLicenseFile(token[:20] + "\n  " + token[20:])
+	if err != nil || !lf.Legacy || lf.Token != token {
+		t.Fatalf("legacy license file = %+v, %v", lf, err)
+	}
+
+	lines := strings.Split(armored, "\n")
+	body := 4 // BEGIN、Version、Product、空行之后的第一行正文
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"empty", ""},
+		{"changed char", replaceLine(lines, body, flipChar(lines[body]))},
+		{"missing line", strings.Join(append(append([]string{}, lines[:body]...), lines[body+1:]...), "\n")},
+		{"truncated", strings.Join(lines[:len(lines)-3], "\n")},
+		{"bad checksum", replaceLine(lines, len(lines)-3, "=AAAA")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeLicenseFile(tt.text); err == nil {
+				t.Fatal("DecodeLicenseFile() should fail")
+			}
+			if tt.text == "" {
+				return
+			}
+			_, err := testVerifier(t, VerifierConfig{}).Verify(tt.text)
+			if !errors.Is(err, ErrMalformed) {
+				t.Fatalf("Verify() error = %v, want %v", err, ErrMalformed)
+			}
+		})
+	}
+}
+
+func replaceLine(lines []string, i int, s string) string {
+	out := append([]string{}, lines...)
+	out[i] = s
+	return strings.Join(out, "\n")
+}
+
+// flipChar 替换 base64 行中间的一个字符
+func flipChar(s string) string {
+	b := []byte(s)
+	i := len(b) / 2
+	if b[i] == 'A' {
+		b[i] = 'B'
+	} else {
+		b[i] = 'A'
+	}
+	return string(b)
+}
+
+func TestShortKey(t *testing.T) {
+	secret := []byte("short key secret")
+	fp := hex.EncodeToString(testDigest[:10])
+	expiry := testNow.Add(30 * 24 * time.Hour)
+	bound, err := IssueShortKey(secret, ShortKey{ProductID: 7, Expiry: expiry, Features: 1<<0 | 1<<3}, fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unbound, err := IssueShortKey(secret, ShortKey{ProductID: 7, Expiry: expiry}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bound) != shortKeyChars+6 {
+		t.Fatalf("short key %q has %d chars", bound, len(bound))
+	}
+
+	k, err := VerifyShortKey(secret, strings.ToLower(bound), fp, testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.ProductID != 7 || !k.HasFeature(0) || !k.HasFeature(3) || k.HasFeature(1) || k.HasFeature(16) {
+		t.Fatalf("unexpected short key %+v", k)
+	}
+	if k.Expiry.Before(expiry) {
+		t.Fatalf("Expiry = %v, should not be before %v", k.Expiry, expiry)
+	}
+
+	tests := []struct {
+		name   string
+		secret []byte
+		code   string
+		fp     string
+		now    time.Time
+		want   error // errAny 表示非指定类型的错误
+	}{
+		{"unbound key on any machine", secret, unbound, strings.Repeat("00", 10), testNow, nil},
+		{"other machine", secret, bound, strings.Repeat("00", 10), testNow, errAny},
+		{"wrong secret", []byte("other secret"), bound, fp, testNow, errAny},
+		{"no secret", nil, bound, fp, testNow, errAny},
+		{"expired", secret, bound, fp, expiry.Add(48 * time.Hour), ErrExpired},
+		{"too short", secret, bound[:len(bound)-5], fp, testNow, errAny},
+		{"typo", secret, "1" + bound[1:], fp, testNow, errAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyShortKey(tt.secret, tt.code, tt.fp, tt.now)
+			switch {
+			case tt.want == nil:
+				if err != nil {
+					t.Fatalf("VerifyShortKey() error = %v", err)
+				}
+			case tt.want == errAny:
+				if err == nil {
+					t.Fatal("VerifyShortKey() should fail")
+				}
+			case !errors.Is(err, tt.want):
+				t.Fatalf("VerifyShortKey() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
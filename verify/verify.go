@@ -0,0 +1,229 @@
+package verify
+
+import (
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"license/hwid"
+
+	"github.com/square/go-jose/v3"
+)
+
+// 许可证校验核心，服务端中间件、命令行工具和共享库共用，保证同一台机器上的校验结果一致
+
+// 校验失败的错误类型，可以用 errors.Is 判断，返回的错误可能附带具体原因
+var (
+	// ErrExpired 许可证已过期（超过宽限期）
+	ErrExpired = errors.New("license expired")
+	// ErrFingerprintMismatch 许可证绑定的不是本机
+	ErrFingerprintMismatch = errors.New("fingerprint mismatch")
+	// ErrBadSignature 许可证签名无效，或者不是受信任的密钥签发的
+	ErrBadSignature = errors.New("invalid license signature")
+	// ErrMalformed 许可证格式错误或无法解密
+	ErrMalformed = errors.New("malformed license")
+	// ErrRevoked 许可证已被吊销
+	ErrRevoked = errors.New("license revoked")
+	// ErrVMNotAllowed 许可证的虚拟机策略不允许在本机运行
+	ErrVMNotAllowed = errors.New("virtual machine not allowed")
+	// ErrNotYetValid 许可证尚未生效（nbf）
+	ErrNotYetValid = errors.New("license not yet valid")
+	// ErrProductMismatch 许可证签发给了其他产品
+	ErrProductMismatch = errors.New("license is for another product")
+)
+
+// ------------------ 公钥加载 ------------------
+
+// LoadPublicKey 加载 PKIX 或 PKCS1 格式的 RSA 公钥
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("invalid pem")
+	}
+	pubIface, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		// try rsa pub1
+		rpub, err2 := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err2 == nil {
+			return rpub, nil
+		}
+		return nil, err
+	}
+	if pub, ok := pubIface.(*rsa.PublicKey); ok {
+		return pub, nil
+	}
+	return nil, errors.New("not rsa pub")
+}
+
+// ------------------ License Claims ------------------
+
+// LicenseSubject 许可证的 sub，固定值，不接受客户输入，客户名称放在 customer 中
+const LicenseSubject = "license"
+
+// Claims 许可证声明
+type Claims struct {
+	Iss         string `json:"iss"`
+	Sub         string `json:"sub"`
+	Customer    string `json:"customer"`
+	Fingerprint string `json:"fingerprint,omitempty"` // 绑定组件哈希时不写入
+	Product     string `json:"product,omitempty"`
+	Nonce       string `json:"nonce,omitempty"` // 离线激活请求的 nonce
+	// Components 各指纹组件的哈希，存在时按匹配策略模糊匹配，而不是比较截断的 Fingerprint
+	Components hwid.Components `json:"fp_components,omitempty"`
+	// Binding 指纹的绑定方式，为空表示 machine，容器中可以绑定宿主机、集群或持久卷
+	Binding string `json:"binding,omitempty"`
+	// VMPolicy 虚拟机策略：physical-only、vm-allowed、vm-instance-uuid，为空不限制
+	VMPolicy string `json:"vm_policy,omitempty"`
+	// InstanceUUID vm-instance-uuid 策略绑定的虚拟机实例 UUID 哈希
+	InstanceUUID string `json:"instance_uuid,omitempty"`
+	// FPScheme 生成 Fingerprint 的指纹算法版本，为 0 表示 hwid.SchemeLegacy
+	FPScheme int `json:"fp_scheme,omitempty"`
+	// FingerprintFull 完整的 256 位指纹（hex），存在时总是校验完整摘要而不是截断为 80 位的 Fingerprint；
+	// 绑定 Components 时不写入，否则组件模糊匹配失去意义
+	FingerprintFull string `json:"fingerprint_full,omitempty"`
+	// FPSalt 指纹的加盐方式：product、customer，为空表示未加盐
+	FPSalt string `json:"fp_salt,omitempty"`
+	// Features 许可证授权的功能
+	Features []string `json:"features,omitempty"`
+
+	// ID 许可证编号，吊销列表按编号吊销，旧许可证没有编号时按令牌摘要识别
+	ID string `json:"jti,omitempty"`
+
+	Iat int64 `json:"iat"`
+	Nbf int64 `json:"nbf,omitempty"` // 生效时间，为 0 表示签发后立即生效
+	Exp int64 `json:"exp"`
+	// Meta omitted
+}
+
+// ComponentBound 许可证是否按组件哈希模糊匹配：只有 machine 绑定使用组件哈希，
+// 此时签发端只写入 Components，不写入 Fingerprint 和 FingerprintFull
+func (c *Claims) ComponentBound() bool {
+	return (c.Binding == "" || c.Binding == hwid.BindingMachine) && len(c.Components) > 0
+}
+
+// ------------------ JWS 验证 ------------------
+
+// Parse 验证许可证签名并检查有效期，加密的许可证（JWE）先用产品密钥解密
+func Parse(pub *rsa.PublicKey, jwsCompact string, encryptionKey []byte) (*Claims, error) {
+	c, err := Decode(pub, jwsCompact, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Unix()
+	if c.Nbf != 0 && now < c.Nbf {
+		return nil, ErrNotYetValid
+	}
+	if now > c.Exp {
+		return nil, ErrExpired
+	}
+	return c, nil
+}
+
+// Decode 验证许可证签名并解析声明，不检查有效期，用于诊断已过期的许可证
+func Decode(pub *rsa.PublicKey, jwsCompact string, encryptionKey []byte) (*Claims, error) {
+	v := &Verifier{keys: []*rsa.PublicKey{pub}, encKey: encryptionKey}
+	c, _, err := v.decode(jwsCompact)
+	return c, err
+}
+
+// ------------------ 指纹校验 ------------------
+
+// FingerprintProvider 本机指纹的来源，默认为按 hwid 包配置计算的 LocalFingerprint，
+// 测试或在其他进程中采集指纹时可以替换
+type FingerprintProvider interface {
+	// Digest 按绑定方式和指纹算法版本计算完整的 256 位指纹
+	Digest(binding string, scheme int) ([]byte, error)
+	// Components 各指纹组件的哈希
+	Components() hwid.Components
+	// Salting 加盐方式，以及按客户加盐时的客户名称
+	Salting() (mode, customer string)
+}
+
+// LocalFingerprint 按 hwid.Configure 的配置计算本机指纹
+type LocalFingerprint struct{}
+
+func (LocalFingerprint) Digest(binding string, scheme int) ([]byte, error) {
+	return hwid.FingerprintDigestFor(binding, scheme)
+}
+
+func (LocalFingerprint) Components() hwid.Components { return hwid.GetComponents() }
+
+func (LocalFingerprint) Salting() (string, string) { return hwid.Salting() }
+
+// CheckFingerprint 校验许可证绑定的机器：携带完整指纹时总是比较完整的 256 位摘要；
+// machine 绑定携带组件哈希时按匹配策略模糊匹配，否则按许可证的绑定方式计算本机指纹并精确比较
+func CheckFingerprint(cl *Claims) error {
+	return checkFingerprint(cl, LocalFingerprint{})
+}
+
+func checkFingerprint(cl *Claims, fp FingerprintProvider) error {
+	mode, customer := fp.Salting()
+	if err := hwid.MatchSalt(cl.FPSalt, cl.Customer, mode, customer); err != nil {
+		return fmt.Errorf("%w: %v", ErrFingerprintMismatch, err)
+	}
+	// 完整指纹存在时总是校验，同时绑定组件哈希的许可证两者都要满足
+	fuzzy := cl.ComponentBound()
+	if cl.FingerprintFull != "" || (!fuzzy && cl.Fingerprint != "") {
+		scheme := cl.FPScheme
+		if scheme == 0 {
+			scheme = hwid.SchemeLegacy
+		}
+		digest, err := fp.Digest(cl.Binding, scheme)
+		if err != nil {
+			return fmt.Errorf("%w: failed to compute local fingerprint: %v", ErrFingerprintMismatch, err)
+		}
+		if cl.FingerprintFull != "" {
+			want, err := hex.DecodeString(cl.FingerprintFull)
+			if err != nil {
+				return fmt.Errorf("invalid full fingerprint: %v", err)
+			}
+			if subtle.ConstantTimeCompare(want, digest) != 1 {
+				return ErrFingerprintMismatch
+			}
+		} else if len(digest) < 10 || cl.Fingerprint != hex.EncodeToString(digest[:10]) {
+			// 激活码只保留摘要的前 80 位
+			return ErrFingerprintMismatch
+		}
+	}
+	if fuzzy {
+		res := hwid.DefaultMatchPolicy.Match(cl.Components, fp.Components())
+		if !res.OK() {
+			return fmt.Errorf("%w: components %v changed", ErrFingerprintMismatch, res.Missed)
+		}
+	}
+	return nil
+}
+
+// ------------------ JWE ------------------
+
+// isEncrypted JWE 紧凑序列化有 5 段，JWS 只有 3 段
+func isEncrypted(token string) bool {
+	return strings.Count(strings.TrimSpace(token), ".") == 4
+}
+
+// decryptLicense 解密 JWE，返回内层 JWS
+func decryptLicense(token string, key []byte) (string, error) {
+	if key == nil {
+		return "", errors.New("license is encrypted but no decryption key is configured")
+	}
+	obj, err := jose.ParseEncrypted(strings.TrimSpace(token))
+	if err != nil {
+		return "", err
+	}
+	out, err := obj.Decrypt(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt license: %v", err)
+	}
+	return string(out), nil
+}